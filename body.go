@@ -0,0 +1,54 @@
+package apitoolkit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// capturingBody tees an outgoing response body as the caller reads it, keeping up to limit bytes.
+// finish is called with the captured bytes and the total size once the body hits EOF or an
+// error, or is closed, whichever comes first. Close may be called while a Read is in progress.
+type capturingBody struct {
+	body   io.ReadCloser
+	limit  int
+	finish func(captured []byte, size int)
+	once   sync.Once
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	size int
+}
+
+func newCapturingBody(body io.ReadCloser, limit int, finish func(captured []byte, size int)) *capturingBody {
+	return &capturingBody{body: body, limit: limit, finish: finish}
+}
+
+func (b *capturingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	b.size += n
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	b.mu.Unlock()
+	if err != nil {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *capturingBody) Close() error {
+	err := b.body.Close()
+	b.done()
+	return err
+}
+
+func (b *capturingBody) done() {
+	b.once.Do(func() {
+		b.mu.Lock()
+		captured, size := bytes.Clone(b.buf.Bytes()), b.size
+		b.mu.Unlock()
+		b.finish(captured, size)
+	})
+}
@@ -0,0 +1,69 @@
+package apitoolkit
+
+import (
+	"context"
+	"time"
+)
+
+// maxBreadcrumbs is the number of breadcrumbs kept per request or job, older ones are dropped
+const maxBreadcrumbs = 50
+
+// Breadcrumb is an event which happened during a request or job, such as an outgoing
+// request or a database query. Breadcrumbs are attached to the errors reported afterwards,
+// to show what led to them.
+type Breadcrumb struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Category  string         `json:"category"`
+	Message   string         `json:"message"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// AddBreadcrumb records a breadcrumb on the current request or job. It does nothing
+// when ctx doesn't belong to a request handled by the middlewares or to a job.
+//
+//	apitoolkit.AddBreadcrumb(ctx, "cache", "cache miss", map[string]any{"key": key})
+func AddBreadcrumb(ctx context.Context, category, message string, data map[string]any) {
+	collector := ErrorCollectorFromContext(ctx)
+	if collector == nil {
+		return
+	}
+	// The data is copied, with values which can't be serialized to JSON kept as their string form
+	var safeData map[string]any
+	if len(data) > 0 {
+		safeData = make(map[string]any, len(data))
+		for k, v := range data {
+			safeData[k] = jsonSafeValue(v)
+		}
+	}
+	collector.addBreadcrumb(Breadcrumb{
+		Timestamp: time.Now(),
+		Category:  category,
+		Message:   message,
+		Data:      safeData,
+	})
+}
+
+// breadcrumbRing keeps the last maxBreadcrumbs breadcrumbs. It is guarded by the ErrorCollector's mutex.
+type breadcrumbRing struct {
+	items []Breadcrumb
+	start int
+}
+
+func (r *breadcrumbRing) add(breadcrumb Breadcrumb) {
+	if len(r.items) < maxBreadcrumbs {
+		r.items = append(r.items, breadcrumb)
+		return
+	}
+	r.items[r.start] = breadcrumb
+	r.start = (r.start + 1) % maxBreadcrumbs
+}
+
+// list returns the breadcrumbs from the oldest to the most recent.
+func (r *breadcrumbRing) list() []Breadcrumb {
+	if len(r.items) == 0 {
+		return nil
+	}
+	breadcrumbs := make([]Breadcrumb, 0, len(r.items))
+	breadcrumbs = append(breadcrumbs, r.items[r.start:]...)
+	return append(breadcrumbs, r.items[:r.start]...)
+}
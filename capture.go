@@ -0,0 +1,40 @@
+package apitoolkit
+
+import "strings"
+
+// CapturePolicy narrows body capture down to the requests that matter.
+// When a policy is set, request and response bodies are only kept if at least
+// one of its conditions matches; otherwise only the request metadata is exported.
+// An empty policy matches no request.
+type CapturePolicy struct {
+	// StatusClasses lists the response status classes to keep bodies for,
+	// e.g. []int{4, 5} for 4xx and 5xx responses.
+	StatusClasses []int
+	// OnError keeps bodies for requests with at least one reported error.
+	OnError bool
+	// Headers keeps bodies for requests carrying any of the given request headers.
+	Headers []string
+}
+
+// Matches reports whether bodies should be kept for a request with the given outcome.
+func (p *CapturePolicy) Matches(statusCode int, errorList []ATError, reqHeaders map[string][]string) bool {
+	if p == nil {
+		return true
+	}
+	for _, class := range p.StatusClasses {
+		if statusCode/100 == class {
+			return true
+		}
+	}
+	if p.OnError && len(errorList) > 0 {
+		return true
+	}
+	for _, name := range p.Headers {
+		for k := range reqHeaders {
+			if strings.EqualFold(k, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
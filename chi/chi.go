@@ -4,15 +4,17 @@ import (
 	"bytes"
 	"context"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httptest"
+	"time"
 
 	apt "github.com/apitoolkit/apitoolkit-go"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/honeycombio/otel-config-go/otelconfig"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -25,51 +27,105 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CapturePolicy optionally restricts body capture to matching requests,
+	// e.g. only 4xx/5xx responses. Bodies are captured for every request when it is nil.
+	CapturePolicy *CapturePolicy
+	// LegacySpanAttributes additionally emits the pre-semconv span attributes
+	// (net.host.name, http.target, http.request.query_params) and original-case header keys.
+	LegacySpanAttributes bool
+	// Logger receives the middleware's diagnostics, which are emitted at debug level when Debug is set.
+	Logger *slog.Logger
+	// RecoverPanics writes a 500 response after recording a panic, instead of re-panicking.
+	RecoverPanics bool
+	// PanicResponseBody is the body of that 500 response, "Internal Server Error" by default.
+	PanicResponseBody []byte
 }
 
+// CapturePolicy decides which requests keep their request and response bodies.
+type CapturePolicy = apt.CapturePolicy
+
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// ReportErrorWith reports an error with options such as its severity or additional context.
+var ReportErrorWith = apt.ReportErrorWith
+
+// Severity of a reported error
+type Severity = apt.Severity
+
+const (
+	SeverityWarning = apt.SeverityWarning
+	SeverityError   = apt.SeverityError
+	SeverityFatal   = apt.SeverityFatal
+)
+
+var WithSeverity = apt.WithSeverity
+var WithHandled = apt.WithHandled
+var WithErrorAttribute = apt.WithErrorAttribute
+var WithGroupingKey = apt.WithGroupingKey
+
+// AddBreadcrumb records an event on the current request, which is attached to the errors reported afterwards.
+var AddBreadcrumb = apt.AddBreadcrumb
+
+// SetErrorDeduplication limits how many errors with the same fingerprint are exported per time window.
+var SetErrorDeduplication = apt.SetErrorDeduplication
+
+// SetLogger redirects the SDK's diagnostics. Passing nil silences them.
+var SetLogger = apt.SetLogger
+
+// StartJob starts tracking a background job, whose errors are reported like a request's.
+var StartJob = apt.StartJob
+
+// CaptureJob runs a function as a background job, reporting its errors and panics.
+var CaptureJob = apt.CaptureJob
+
+// Go runs a function in a new goroutine as part of the current request, reporting its errors and panics.
+var Go = apt.Go
+
+// NewGroup returns an errgroup-like Group of goroutines run as part of the current request.
+var NewGroup = apt.NewGroup
+
+// Group runs goroutines as part of the current request and waits for them.
+type Group = apt.Group
+
 func Middleware(config Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			start := time.Now()
 			tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-			newCtx, span := tracer.Start(req.Context(), "apitoolkit-http-span")
+			newCtx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			newCtx, span := tracer.Start(newCtx, req.Method, trace.WithSpanKind(trace.SpanKindServer))
 			msgID := uuid.Must(uuid.NewRandom())
+			parentID := apt.ParentIDFromHeader(req.Header.Get(apt.TraceParentIDHeader))
 			newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
-			errorList := []apt.ATError{}
-			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
+			errorList := apt.NewErrorCollector()
+			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, errorList)
 			req = req.WithContext(newCtx)
 
 			reqBuf, _ := io.ReadAll(req.Body)
 			req.Body.Close()
 			req.Body = io.NopCloser(bytes.NewBuffer(reqBuf))
 
-			rec := httptest.NewRecorder()
-			next.ServeHTTP(rec, req)
-			recRes := rec.Result()
-			for k, v := range recRes.Header {
-				for _, vv := range v {
-					res.Header().Add(k, vv)
-				}
-			}
-			resBody, _ := io.ReadAll(recRes.Body)
-			res.WriteHeader(recRes.StatusCode)
-			res.Write(resBody)
-
 			aptConfig := apt.Config{
-				ServiceName:         config.ServiceName,
-				ServiceVersion:      config.ServiceVersion,
-				Tags:                config.Tags,
-				Debug:               config.Debug,
-				CaptureRequestBody:  config.CaptureRequestBody,
-				CaptureResponseBody: config.CaptureResponseBody,
-				RedactHeaders:       config.RedactHeaders,
-				RedactRequestBody:   config.RedactRequestBody,
-				RedactResponseBody:  config.RedactResponseBody,
+				ServiceName:          config.ServiceName,
+				ServiceVersion:       config.ServiceVersion,
+				Tags:                 config.Tags,
+				Debug:                config.Debug,
+				CaptureRequestBody:   config.CaptureRequestBody,
+				CaptureResponseBody:  config.CaptureResponseBody,
+				RedactHeaders:        config.RedactHeaders,
+				RedactRequestBody:    config.RedactRequestBody,
+				RedactResponseBody:   config.RedactResponseBody,
+				CapturePolicy:        config.CapturePolicy,
+				LegacySpanAttributes: config.LegacySpanAttributes,
+				Logger:               config.Logger,
+				RecoverPanics:        config.RecoverPanics,
+				PanicResponseBody:    config.PanicResponseBody,
 			}
 
+			recorded := apt.ServeRecorded(res, req, next, span, aptConfig)
+
 			chiCtx := chi.RouteContext(req.Context())
 			vars := map[string]string{}
 			for i, key := range chiCtx.URLParams.Keys {
@@ -79,27 +135,29 @@ func Middleware(config Config) func(http.Handler) http.Handler {
 			}
 
 			payload := apt.BuildPayload(apt.GoGorillaMux,
-				req, recRes.StatusCode,
-				reqBuf, resBody, recRes.Header, vars, chiCtx.RoutePattern(),
+				req, recorded.StatusCode,
+				reqBuf, recorded.Body, recorded.Header, vars, chiCtx.RoutePattern(),
 				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
 				errorList,
 				msgID,
-				nil,
+				parentID,
 				aptConfig,
 			)
-			if config.Debug {
-				log.Println(payload)
-			}
+			payload.Duration = time.Since(start)
 
 			apt.CreateSpan(payload, aptConfig, span)
-
+			recorded.Repanic(aptConfig)
 		})
 	}
 }
 
 func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+	return apt.ConfigureOpenTelemetry(opts...)
+}
+
+// ConfigureOpenTelemetryLogs sets up the logs pipeline used by the slog handler's ExportLogs.
+func ConfigureOpenTelemetryLogs(opts ...otelconfig.Option) (func(), error) {
+	return apt.ConfigureOpenTelemetryLogs(opts...)
 }
 
 var WithServiceName = otelconfig.WithServiceName
@@ -115,6 +173,15 @@ var WithTracesEnabled = otelconfig.WithTracesEnabled
 var WithSpanProcessor = otelconfig.WithSpanProcessor
 var WithSampler = otelconfig.WithSampler
 
+// NewSlogHandler wraps a slog.Handler to tie log records to the current request.
+var NewSlogHandler = apt.NewSlogHandler
+
+// SlogHandlerOptions configures the handler returned by NewSlogHandler.
+type SlogHandlerOptions = apt.SlogHandlerOptions
+
+// PrometheusHandler serves the API metrics collected by the middleware in the Prometheus text format.
+var PrometheusHandler = apt.PrometheusHandler
+
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
 	return apt.HTTPClient(ctx, opts...)
 }
@@ -122,3 +189,15 @@ func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Clien
 var WithRedactHeaders = apt.WithRedactHeaders
 var WithRedactRequestBody = apt.WithRedactRequestBody
 var WithRedactResponseBody = apt.WithRedactResponseBody
+var WithCapturePolicy = apt.WithCapturePolicy
+var WithLegacySpanAttributes = apt.WithLegacySpanAttributes
+var WithMaxResponseBodyCapture = apt.WithMaxResponseBodyCapture
+
+// InstrumentDefaultTransport traces requests sent with http.DefaultClient and http.DefaultTransport.
+var InstrumentDefaultTransport = apt.InstrumentDefaultTransport
+
+// InstrumentDefaultClient traces requests sent with http.DefaultClient, leaving http.DefaultTransport unchanged.
+var InstrumentDefaultClient = apt.InstrumentDefaultClient
+
+// InstrumentClient makes an existing *http.Client, e.g. one of a third-party SDK, trace its requests.
+var InstrumentClient = apt.InstrumentClient
@@ -0,0 +1,92 @@
+package apitoolkit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedFingerprints bounds the deduplicator's memory. Expired windows are pruned once it is reached,
+// and errors with new fingerprints are exported without being tracked while it still is.
+const maxTrackedFingerprints = 1024
+
+// errorDedup is disabled until SetErrorDeduplication is called
+var errorDedup = &errorDeduplicator{
+	windows: map[string]*fingerprintWindow{},
+}
+
+// SetErrorDeduplication limits the errors exported with the same fingerprint to maxPerWindow
+// per window, so an outage failing every request doesn't export the same error thousands of times.
+// Further occurrences are dropped from spans, which record how many were suppressed instead, and the
+// next error exported with that fingerprint carries the count in ATError.SuppressedCount.
+// Metrics still count every occurrence. Deduplication is off by default, a zero window or limit disables it.
+//
+//	apitoolkit.SetErrorDeduplication(time.Minute, 10)
+func SetErrorDeduplication(window time.Duration, maxPerWindow int) {
+	errorDedup.mu.Lock()
+	defer errorDedup.mu.Unlock()
+	errorDedup.window = window
+	errorDedup.maxPerWindow = maxPerWindow
+	errorDedup.windows = map[string]*fingerprintWindow{}
+}
+
+type errorDeduplicator struct {
+	mu           sync.Mutex
+	window       time.Duration
+	maxPerWindow int
+	windows      map[string]*fingerprintWindow
+}
+
+type fingerprintWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// filter returns the errors to export, and the number of errors suppressed.
+func (d *errorDeduplicator) filter(errorList []ATError) ([]ATError, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.window <= 0 || d.maxPerWindow <= 0 || len(errorList) == 0 {
+		return errorList, 0
+	}
+
+	now := time.Now()
+	if len(d.windows) >= maxTrackedFingerprints {
+		for fp, w := range d.windows {
+			if now.Sub(w.start) >= d.window {
+				delete(d.windows, fp)
+			}
+		}
+	}
+
+	kept := make([]ATError, 0, len(errorList))
+	suppressed := 0
+	for _, atError := range errorList {
+		if atError.Fingerprint == "" {
+			kept = append(kept, atError)
+			continue
+		}
+		w := d.windows[atError.Fingerprint]
+		if w == nil && len(d.windows) >= maxTrackedFingerprints {
+			kept = append(kept, atError)
+			continue
+		}
+		if w == nil || now.Sub(w.start) >= d.window {
+			next := &fingerprintWindow{start: now}
+			if w != nil {
+				// Occurrences suppressed in the previous window are reported on the first error of this one
+				atError.SuppressedCount = w.suppressed
+			}
+			w = next
+			d.windows[atError.Fingerprint] = w
+		}
+		w.count++
+		if w.count > d.maxPerWindow {
+			w.suppressed++
+			suppressed++
+			continue
+		}
+		kept = append(kept, atError)
+	}
+	return kept, suppressed
+}
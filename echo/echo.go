@@ -5,15 +5,20 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"time"
 
 	apt "github.com/apitoolkit/apitoolkit-go"
 	"github.com/google/uuid"
 	"github.com/honeycombio/otel-config-go/otelconfig"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // bodyDumpResponseWriter use to preserve the http response body during request processing
@@ -48,25 +53,87 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CapturePolicy optionally restricts body capture to matching requests,
+	// e.g. only 4xx/5xx responses. Bodies are captured for every request when it is nil.
+	CapturePolicy *CapturePolicy
+	// LegacySpanAttributes additionally emits the pre-semconv span attributes
+	// (net.host.name, http.target, http.request.query_params) and original-case header keys.
+	LegacySpanAttributes bool
+	// Logger receives the middleware's diagnostics, which are emitted at debug level when Debug is set.
+	Logger *slog.Logger
+	// RecoverPanics writes a 500 response after recording a panic, instead of re-panicking.
+	RecoverPanics bool
+	// PanicResponseBody is the body of that 500 response, "Internal Server Error" by default.
+	PanicResponseBody []byte
+	// IgnoreClientErrors skips recording errors returned by handlers which result in a 4xx response,
+	// such as validation or not found errors.
+	IgnoreClientErrors bool
 }
 
+// CapturePolicy decides which requests keep their request and response bodies.
+type CapturePolicy = apt.CapturePolicy
+
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// ReportErrorWith reports an error with options such as its severity or additional context.
+var ReportErrorWith = apt.ReportErrorWith
+
+// Severity of a reported error
+type Severity = apt.Severity
+
+const (
+	SeverityWarning = apt.SeverityWarning
+	SeverityError   = apt.SeverityError
+	SeverityFatal   = apt.SeverityFatal
+)
+
+var WithSeverity = apt.WithSeverity
+var WithHandled = apt.WithHandled
+var WithErrorAttribute = apt.WithErrorAttribute
+var WithGroupingKey = apt.WithGroupingKey
+
+// AddBreadcrumb records an event on the current request, which is attached to the errors reported afterwards.
+var AddBreadcrumb = apt.AddBreadcrumb
+
+// SetErrorDeduplication limits how many errors with the same fingerprint are exported per time window.
+var SetErrorDeduplication = apt.SetErrorDeduplication
+
+// SetLogger redirects the SDK's diagnostics. Passing nil silences them.
+var SetLogger = apt.SetLogger
+
+// StartJob starts tracking a background job, whose errors are reported like a request's.
+var StartJob = apt.StartJob
+
+// CaptureJob runs a function as a background job, reporting its errors and panics.
+var CaptureJob = apt.CaptureJob
+
+// Go runs a function in a new goroutine as part of the current request, reporting its errors and panics.
+var Go = apt.Go
+
+// NewGroup returns an errgroup-like Group of goroutines run as part of the current request.
+var NewGroup = apt.NewGroup
+
+// Group runs goroutines as part of the current request and waits for them.
+type Group = apt.Group
+
 // EchoMiddleware middleware for echo framework, collects requests, response and publishes the payload
 func Middleware(config Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) (err error) {
 			tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-			newCtx, span := tracer.Start(ctx.Request().Context(), "apitoolkit-http-span")
+			start := time.Now()
+			newCtx := otel.GetTextMapPropagator().Extract(ctx.Request().Context(), propagation.HeaderCarrier(ctx.Request().Header))
+			newCtx, span := tracer.Start(newCtx, ctx.Request().Method, trace.WithSpanKind(trace.SpanKindServer))
 
 			msgID := uuid.Must(uuid.NewRandom())
+			parentID := apt.ParentIDFromHeader(ctx.Request().Header.Get(apt.TraceParentIDHeader))
 			ctx.Set(string(apt.CurrentRequestMessageID), msgID)
 
-			errorList := []apt.ATError{}
-			ctx.Set(string(apt.ErrorListCtxKey), &errorList)
-			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
+			errorList := apt.NewErrorCollector()
+			ctx.Set(string(apt.ErrorListCtxKey), errorList)
+			newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, errorList)
 			newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
 
 			// add span context to the request context
@@ -88,60 +155,107 @@ func Middleware(config Config) echo.MiddlewareFunc {
 				pathParams[paramName] = ctx.Param(paramName)
 			}
 			aptConfig := apt.Config{
-				ServiceName:         config.ServiceName,
-				ServiceVersion:      config.ServiceVersion,
-				Tags:                config.Tags,
-				CaptureRequestBody:  config.CaptureRequestBody,
-				CaptureResponseBody: config.CaptureResponseBody,
-				RedactHeaders:       config.RedactHeaders,
-				RedactRequestBody:   config.RedactRequestBody,
-				RedactResponseBody:  config.RedactResponseBody,
+				ServiceName:          config.ServiceName,
+				ServiceVersion:       config.ServiceVersion,
+				Tags:                 config.Tags,
+				Debug:                config.Debug,
+				CaptureRequestBody:   config.CaptureRequestBody,
+				CaptureResponseBody:  config.CaptureResponseBody,
+				RedactHeaders:        config.RedactHeaders,
+				RedactRequestBody:    config.RedactRequestBody,
+				RedactResponseBody:   config.RedactResponseBody,
+				CapturePolicy:        config.CapturePolicy,
+				LegacySpanAttributes: config.LegacySpanAttributes,
+				Logger:               config.Logger,
+				RecoverPanics:        config.RecoverPanics,
+				PanicResponseBody:    config.PanicResponseBody,
 			}
 
 			defer func() {
-				if err := recover(); err != nil {
-					if _, ok := err.(error); !ok {
-						err = errors.New(err.(string))
+				if rec := recover(); rec != nil {
+					// http.ErrAbortHandler aborts the response on purpose, it is not an error
+					if rec == http.ErrAbortHandler {
+						panic(rec)
+					}
+					apt.RecoverPanic(ctx.Request().Context(), span, rec)
+					if config.RecoverPanics {
+						if !ctx.Response().Committed {
+							ctx.Blob(http.StatusInternalServerError, "text/plain; charset=utf-8", aptConfig.PanicResponse())
+						}
+						err = nil
 					}
-					apt.ReportError(ctx.Request().Context(), err.(error))
-					payload := apt.BuildPayload(apt.GoDefaultSDKType,
+					payload := apt.BuildPayload(apt.GoEchoSDKType,
 						ctx.Request(), 500,
 						reqBuf, resBody.Bytes(), ctx.Response().Header().Clone(),
 						pathParams, ctx.Path(),
 						config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
 						errorList,
 						msgID,
-						nil,
+						parentID,
 						aptConfig,
 					)
+					payload.Duration = time.Since(start)
 					apt.CreateSpan(payload, aptConfig, span)
-					panic(err)
+					if !config.RecoverPanics {
+						panic(rec)
+					}
 				}
 			}()
 
 			// pass on request handling
 			err = next(ctx)
+			if err != nil {
+				reportEchoError(ctx.Request().Context(), err, config.IgnoreClientErrors)
+				// Run the error handler now rather than once the middlewares have returned,
+				// so the payload has the status and body the client receives. The error is handled
+				// then, so it isn't returned for echo to run the handler a second time.
+				if !ctx.Response().Committed {
+					ctx.Error(err)
+					err = nil
+				}
+			}
 
 			// proceed post-response processing
-			payload := apt.BuildPayload(apt.GoDefaultSDKType,
+			payload := apt.BuildPayload(apt.GoEchoSDKType,
 				ctx.Request(), ctx.Response().Status,
 				reqBuf, resBody.Bytes(), ctx.Response().Header().Clone(),
 				pathParams, ctx.Path(),
 				config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
 				errorList,
 				msgID,
-				nil,
+				parentID,
 				aptConfig,
 			)
+			payload.Duration = time.Since(start)
 			apt.CreateSpan(payload, aptConfig, span)
 			return err
 		}
 	}
 }
 
+// reportEchoError records an error returned by a handler. For an *echo.HTTPError, its code
+// is the response status and its internal error, when set, is the error reported.
+func reportEchoError(ctx context.Context, err error, ignoreClientErrors bool) {
+	statusCode := http.StatusInternalServerError
+	var opts []apt.ReportOption
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		statusCode = httpErr.Code
+		opts = append(opts, apt.WithErrorAttribute("echo.message", fmt.Sprint(httpErr.Message)))
+		if httpErr.Internal != nil {
+			err = httpErr.Internal
+		}
+	}
+	apt.ReportHandlerError(ctx, err, statusCode, ignoreClientErrors, opts...)
+}
+
 func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+	return apt.ConfigureOpenTelemetry(opts...)
+}
+
+// ConfigureOpenTelemetryLogs sets up the logs pipeline used by the slog handler's ExportLogs.
+func ConfigureOpenTelemetryLogs(opts ...otelconfig.Option) (func(), error) {
+	return apt.ConfigureOpenTelemetryLogs(opts...)
 }
 
 var WithServiceName = otelconfig.WithServiceName
@@ -157,6 +271,15 @@ var WithTracesEnabled = otelconfig.WithTracesEnabled
 var WithSpanProcessor = otelconfig.WithSpanProcessor
 var WithSampler = otelconfig.WithSampler
 
+// NewSlogHandler wraps a slog.Handler to tie log records to the current request.
+var NewSlogHandler = apt.NewSlogHandler
+
+// SlogHandlerOptions configures the handler returned by NewSlogHandler.
+type SlogHandlerOptions = apt.SlogHandlerOptions
+
+// PrometheusHandler serves the API metrics collected by the middleware in the Prometheus text format.
+var PrometheusHandler = apt.PrometheusHandler
+
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
 	return apt.HTTPClient(ctx, opts...)
 }
@@ -164,3 +287,15 @@ func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Clien
 var WithRedactHeaders = apt.WithRedactHeaders
 var WithRedactRequestBody = apt.WithRedactRequestBody
 var WithRedactResponseBody = apt.WithRedactResponseBody
+var WithCapturePolicy = apt.WithCapturePolicy
+var WithLegacySpanAttributes = apt.WithLegacySpanAttributes
+var WithMaxResponseBodyCapture = apt.WithMaxResponseBodyCapture
+
+// InstrumentDefaultTransport traces requests sent with http.DefaultClient and http.DefaultTransport.
+var InstrumentDefaultTransport = apt.InstrumentDefaultTransport
+
+// InstrumentDefaultClient traces requests sent with http.DefaultClient, leaving http.DefaultTransport unchanged.
+var InstrumentDefaultClient = apt.InstrumentDefaultClient
+
+// InstrumentClient makes an existing *http.Client, e.g. one of a third-party SDK, trace its requests.
+var InstrumentClient = apt.InstrumentClient
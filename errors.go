@@ -2,12 +2,17 @@ package apitoolkit
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"math"
 	"reflect"
+	"sync"
 	"time"
 
 	gerrors "github.com/go-errors/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ATError is the Apitoolkit error type/object
@@ -18,42 +23,361 @@ type ATError struct {
 	Message          string    `json:"message,omitempty"`
 	RootErrorMessage string    `json:"root_error_message,omitempty"`
 	StackTrace       string    `json:"stack_trace,omitempty"`
+	// Frames is the structured form of StackTrace, innermost frame first
+	Frames []StackFrame `json:"frames,omitempty"`
+	// Fingerprint identifies recurring occurrences of the same error, for grouping
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Chain holds every layer of the error, from the reported error down to its causes
+	Chain *ErrorNode `json:"chain,omitempty"`
+	// Severity is SeverityError unless set with WithSeverity
+	Severity Severity `json:"severity,omitempty"`
+	// Handled is false for panics and errors reported WithHandled(false)
+	Handled bool `json:"handled"`
+	// Attributes is additional context added with WithErrorAttribute
+	Attributes map[string]any `json:"attributes,omitempty"`
+	// GroupingKey overrides the computed fingerprint when set with WithGroupingKey
+	GroupingKey string `json:"grouping_key,omitempty"`
+	// Breadcrumbs are the events recorded on the request or job before the error was reported
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs,omitempty"`
+	// SuppressedCount is the number of occurrences of this error dropped by the deduplication
+	// during the previous window, see SetErrorDeduplication
+	SuppressedCount int `json:"suppressed_count,omitempty"`
+}
+
+// Severity of a reported error
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+	SeverityFatal   Severity = "fatal"
+)
+
+// ReportOption customizes an error reported with ReportErrorWith.
+type ReportOption func(*ATError)
+
+// WithSeverity sets the severity of the error, e.g. SeverityWarning for expected business errors.
+func WithSeverity(severity Severity) ReportOption {
+	return func(e *ATError) {
+		e.Severity = severity
+	}
+}
+
+// WithHandled marks whether the error was handled by the application. Reported errors are handled by default.
+func WithHandled(handled bool) ReportOption {
+	return func(e *ATError) {
+		e.Handled = handled
+	}
+}
+
+// WithErrorAttribute adds a key/value pair of context to the error.
+// Values which can't be serialized to JSON, such as funcs or NaN, are kept as their string form.
+func WithErrorAttribute(key string, value any) ReportOption {
+	return func(e *ATError) {
+		if e.Attributes == nil {
+			e.Attributes = map[string]any{}
+		}
+		e.Attributes[key] = jsonSafeValue(value)
+	}
+}
+
+// WithGroupingKey groups the error with every other error reported with the same key,
+// instead of by its type and stack trace.
+func WithGroupingKey(key string) ReportOption {
+	return func(e *ATError) {
+		e.GroupingKey = key
+		e.Fingerprint = fingerprint("grouping_key", key, "", nil)
+	}
+}
+
+// ErrorNode is one layer of an error chain. Errors joined with errors.Join
+// or wrapped with several %w verbs have one cause per joined error.
+type ErrorNode struct {
+	Type    string       `json:"type"`
+	Message string       `json:"message"`
+	Causes  []*ErrorNode `json:"causes,omitempty"`
+}
+
+// maxChainDepth guards against errors whose Unwrap methods form a cycle
+const maxChainDepth = 32
+
+func buildErrorChain(err error, depth int) *ErrorNode {
+	node := &ErrorNode{Type: reflect.TypeOf(err).String(), Message: err.Error()}
+	if depth == maxChainDepth {
+		return node
+	}
+	var causes []error
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		causes = e.Unwrap()
+	case interface{ Unwrap() error }:
+		causes = []error{e.Unwrap()}
+	}
+	for _, cause := range causes {
+		if cause != nil {
+			node.Causes = append(node.Causes, buildErrorChain(cause, depth+1))
+		}
+	}
+	return node
+}
+
+// ErrorCollector gathers the errors reported during a request or a job, along with its breadcrumbs.
+// It is stored in the context under ErrorListCtxKey and is safe for concurrent use,
+// so handlers may report errors from several goroutines.
+type ErrorCollector struct {
+	mu          sync.Mutex
+	errors      []ATError
+	breadcrumbs breadcrumbRing
+	closed      bool
+}
+
+func NewErrorCollector() *ErrorCollector {
+	return &ErrorCollector{errors: []ATError{}}
+}
+
+// Add appends an error, with the breadcrumbs recorded until now. It returns false when the
+// collector was already closed, i.e. the payload of its request or job has already been built.
+func (c *ErrorCollector) Add(atError ATError) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	if atError.Breadcrumbs == nil {
+		atError.Breadcrumbs = c.breadcrumbs.list()
+	}
+	c.errors = append(c.errors, atError)
+	return true
+}
+
+func (c *ErrorCollector) addBreadcrumb(breadcrumb Breadcrumb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.breadcrumbs.add(breadcrumb)
+	}
+}
+
+// Breadcrumbs returns the breadcrumbs recorded so far, from the oldest to the most recent.
+func (c *ErrorCollector) Breadcrumbs() []Breadcrumb {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.breadcrumbs.list()
+}
+
+// Errors returns a copy of the errors collected so far.
+func (c *ErrorCollector) Errors() []ATError {
+	if c == nil {
+		return []ATError{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ATError{}, c.errors...)
+}
+
+// Close stops collecting and returns the collected errors.
+// Errors added afterwards are rejected, rather than silently lost.
+func (c *ErrorCollector) Close() []ATError {
+	if c == nil {
+		return []ATError{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return append([]ATError{}, c.errors...)
+}
+
+// ErrorCollectorFromContext returns the collector of the current request or job, or nil.
+func ErrorCollectorFromContext(ctx context.Context) *ErrorCollector {
+	collector, _ := ctx.Value(ErrorListCtxKey).(*ErrorCollector)
+	return collector
 }
 
 // ReportError Allows you to report an error from your server to APIToolkit.
 // This error would be associated with a given request,
 // and helps give a request more context especially when investigating incidents
 func ReportError(ctx context.Context, err error) {
+	reportError(ctx, err, nil)
+}
+
+// ReportErrorWith reports an error like ReportError, with options describing it.
+//
+//	apitoolkit.ReportErrorWith(ctx, err,
+//		apitoolkit.WithSeverity(apitoolkit.SeverityWarning),
+//		apitoolkit.WithErrorAttribute("order_id", orderID),
+//	)
+func ReportErrorWith(ctx context.Context, err error, opts ...ReportOption) {
+	reportError(ctx, err, opts)
+}
+
+// ReportHandlerError reports an error returned by a handler to its framework, which responds
+// to it with statusCode. Client errors (4xx) are reported as warnings, or not at all when
+// ignoreClientErrors is set. It is used by the middlewares of frameworks whose handlers return errors.
+func ReportHandlerError(ctx context.Context, err error, statusCode int, ignoreClientErrors bool, opts ...ReportOption) {
 	if err == nil {
 		return
 	}
+	defaults := []ReportOption{WithErrorAttribute("http.response.status_code", statusCode)}
+	if statusCode >= 400 && statusCode < 500 {
+		if ignoreClientErrors {
+			return
+		}
+		defaults = append(defaults, WithSeverity(SeverityWarning))
+	}
+	reportError(ctx, err, append(defaults, opts...))
+}
 
-	errorList, ok := ctx.Value(ErrorListCtxKey).(*[]ATError)
-	if !ok {
-		log.Printf("APIToolkit: ErrorList context key was not found in the context. Is the middleware configured correctly? Error will not be notified. Error: %v \n", err)
+func reportError(ctx context.Context, err error, opts []ReportOption) {
+	if err == nil {
 		return
 	}
 
-	*errorList = append(*errorList, BuildError(err))
+	atError := buildError(err, 2)
+	for _, opt := range opts {
+		opt(&atError)
+	}
+	if collector := ErrorCollectorFromContext(ctx); collector != nil && collector.Add(atError) {
+		return
+	}
+	// Outside of a request or job, or once its payload was built, the error can
+	// still be recorded on the current span if it is not finished yet
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		recordExceptions(span, []ATError{atError})
+		return
+	}
+	sdkLogger.Load().Warn("APIToolkit: no active request or job was found in the context. Is the middleware configured correctly? Error will not be notified.", "error", err)
 }
 
+// BuildError converts err to an ATError. The stack trace is the one carried by err when
+// it was created with go-errors or pkg/errors, and the caller's stack otherwise.
 func BuildError(err error) ATError {
+	return buildError(err, 1)
+}
+
+// buildError builds an ATError, recording the caller's stack skip frames above its caller
+// when err doesn't carry a stack trace.
+func buildError(err error, skip int) ATError {
+	if panicErr, ok := err.(*PanicError); ok {
+		rootErrorType := fmt.Sprintf("%T", panicErr.Value)
+		frames := stackFrames(panicErr.callers)
+		return ATError{
+			When:             time.Now(),
+			ErrorType:        "panic",
+			RootErrorType:    rootErrorType,
+			RootErrorMessage: fmt.Sprint(panicErr.Value),
+			Message:          panicErr.Error(),
+			StackTrace:       string(panicErr.Stack),
+			Frames:           frames,
+			Fingerprint:      fingerprint("panic", rootErrorType, panicErr.Error(), frames),
+			Chain:            buildErrorChain(panicErr, 0),
+			Severity:         SeverityFatal,
+			Handled:          false,
+		}
+	}
+
 	errType := reflect.TypeOf(err).String()
 
 	rootError := rootCause(err)
 	rootErrorType := reflect.TypeOf(rootError).String()
-	errW := gerrors.Wrap(err, 2)
+
+	var stackTrace string
+	pcs := errorCallers(err)
+	if gerr, ok := err.(*gerrors.Error); ok {
+		stackTrace = gerr.ErrorStack()
+	} else if len(pcs) == 0 {
+		errW := gerrors.Wrap(err, skip+1)
+		pcs = errW.Callers()
+		stackTrace = errW.ErrorStack()
+	}
+	frames := stackFrames(pcs)
+	if stackTrace == "" {
+		stackTrace = errType + " " + err.Error() + "\n" + formatFrames(frames)
+	}
+
 	return ATError{
 		When:             time.Now(),
 		ErrorType:        errType,
 		RootErrorType:    rootErrorType,
 		RootErrorMessage: rootError.Error(),
-		Message:          errW.Error(),
-		StackTrace:       errW.ErrorStack(),
+		Message:          err.Error(),
+		StackTrace:       stackTrace,
+		Frames:           frames,
+		Fingerprint:      fingerprint(errType, rootErrorType, err.Error(), frames),
+		Chain:            buildErrorChain(err, 0),
+		Severity:         SeverityError,
+		Handled:          true,
+	}
+}
+
+// recordExceptions records each error as a standard exception event,
+// so generic OTel backends can see them too.
+func recordExceptions(span trace.Span, errorList []ATError) {
+	for _, atError := range errorList {
+		attrs := []attribute.KeyValue{
+			attribute.String("exception.type", atError.ErrorType),
+			attribute.String("exception.message", atError.Message),
+			attribute.String("exception.stacktrace", atError.StackTrace),
+			attribute.String("apitoolkit.error.fingerprint", atError.Fingerprint),
+			attribute.String("apitoolkit.error.severity", string(atError.Severity)),
+			attribute.Bool("apitoolkit.error.handled", atError.Handled),
+		}
+		if atError.GroupingKey != "" {
+			attrs = append(attrs, attribute.String("apitoolkit.error.grouping_key", atError.GroupingKey))
+		}
+		for key, value := range atError.Attributes {
+			attrs = append(attrs, errorAttribute("apitoolkit.error.attributes."+key, value))
+		}
+		span.AddEvent("exception", trace.WithTimestamp(atError.When), trace.WithAttributes(attrs...))
+	}
+}
+
+// errorAttribute converts an error attribute value to a span attribute, keeping its type when OTel supports it.
+func errorAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+// jsonSafeValue converts a value to one json.Marshal accepts, so a single unsupported
+// value doesn't prevent the serialization of every error of the request.
+func jsonSafeValue(value any) any {
+	switch v := value.(type) {
+	case nil, string, bool, int, int64:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprint(v)
+		}
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Sprint(value)
 	}
+	return value
 }
 
 // rootCause recursively unwraps an error and returns the original cause.
+// Joined errors have no single cause, so the unwrapping stops at the join;
+// the individual errors are kept in ATError.Chain.
 func rootCause(err error) error {
 	for {
 		cause := errors.Unwrap(err)
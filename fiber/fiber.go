@@ -2,14 +2,16 @@ package apitoolkitfiber
 
 import (
 	"context"
-	"errors"
+	"log/slog"
 	"net/http"
+	"time"
 
 	apt "github.com/apitoolkit/apitoolkit-go"
 	fiber "github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/honeycombio/otel-config-go/otelconfig"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -22,34 +24,60 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CapturePolicy optionally restricts body capture to matching requests,
+	// e.g. only 4xx/5xx responses. Bodies are captured for every request when it is nil.
+	CapturePolicy *CapturePolicy
+	// LegacySpanAttributes additionally emits the pre-semconv span attributes
+	// (net.host.name, http.target, http.request.query_params) and original-case header keys.
+	LegacySpanAttributes bool
+	// Logger receives the middleware's diagnostics, which are emitted at debug level when Debug is set.
+	Logger *slog.Logger
+	// RecoverPanics writes a 500 response after recording a panic, instead of re-panicking.
+	RecoverPanics bool
+	// PanicResponseBody is the body of that 500 response, "Internal Server Error" by default.
+	PanicResponseBody []byte
+	// IgnoreClientErrors skips recording errors returned by handlers which result in a 4xx response,
+	// such as validation or not found errors.
+	IgnoreClientErrors bool
 }
 
+// CapturePolicy decides which requests keep their request and response bodies.
+type CapturePolicy = apt.CapturePolicy
+
 func getAptConfig(config Config) apt.Config {
 	return apt.Config{
-		ServiceName:         config.ServiceName,
-		ServiceVersion:      config.ServiceVersion,
-		Tags:                config.Tags,
-		Debug:               config.Debug,
-		CaptureRequestBody:  config.CaptureRequestBody,
-		CaptureResponseBody: config.CaptureResponseBody,
-		RedactHeaders:       config.RedactHeaders,
-		RedactRequestBody:   config.RedactRequestBody,
-		RedactResponseBody:  config.RedactResponseBody,
+		ServiceName:          config.ServiceName,
+		ServiceVersion:       config.ServiceVersion,
+		Tags:                 config.Tags,
+		Debug:                config.Debug,
+		CaptureRequestBody:   config.CaptureRequestBody,
+		CaptureResponseBody:  config.CaptureResponseBody,
+		RedactHeaders:        config.RedactHeaders,
+		RedactRequestBody:    config.RedactRequestBody,
+		RedactResponseBody:   config.RedactResponseBody,
+		CapturePolicy:        config.CapturePolicy,
+		LegacySpanAttributes: config.LegacySpanAttributes,
+		Logger:               config.Logger,
+		RecoverPanics:        config.RecoverPanics,
+		PanicResponseBody:    config.PanicResponseBody,
 	}
 }
 
 func Middleware(config Config) fiber.Handler {
-	return func(ctx *fiber.Ctx) error {
-		baseCtx := ctx.UserContext()
+	return func(ctx *fiber.Ctx) (err error) {
+		start := time.Now()
+		middlewareRoute := ctx.Route()
+		baseCtx := otel.GetTextMapPropagator().Extract(ctx.UserContext(), apt.FastHTTPHeaderCarrier{Header: &ctx.Request().Header})
 		tracer := otel.GetTracerProvider().Tracer(config.ServiceName)
-		newCtx, span := tracer.Start(baseCtx, "apitoolkit-http-span")
+		newCtx, span := tracer.Start(baseCtx, ctx.Method(), trace.WithSpanKind(trace.SpanKindServer))
 
 		msgID := uuid.Must(uuid.NewRandom())
+		parentID := apt.ParentIDFromHeader(ctx.Get(apt.TraceParentIDHeader))
 		ctx.Locals(string(apt.CurrentRequestMessageID), msgID)
-		errorList := []apt.ATError{}
-		ctx.Locals(string(apt.ErrorListCtxKey), &errorList)
+		errorList := apt.NewErrorCollector()
+		ctx.Locals(string(apt.ErrorListCtxKey), errorList)
 
-		newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, &errorList)
+		newCtx = context.WithValue(newCtx, apt.ErrorListCtxKey, errorList)
 		newCtx = context.WithValue(newCtx, apt.CurrentRequestMessageID, msgID)
 		ctx.SetUserContext(newCtx)
 
@@ -59,52 +87,122 @@ func Middleware(config Config) fiber.Handler {
 		}
 		aptConfig := getAptConfig(config)
 		defer func() {
-			if err := recover(); err != nil {
-				if _, ok := err.(error); !ok {
-					err = errors.New(err.(string))
+			if rec := recover(); rec != nil {
+				apt.RecoverPanic(ctx.UserContext(), span, rec)
+				if config.RecoverPanics {
+					ctx.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+					ctx.Status(http.StatusInternalServerError).Send(aptConfig.PanicResponse())
+					err = nil
 				}
-				apt.ReportError(ctx.UserContext(), err.(error))
 				payload := apt.BuildFastHTTPPayload(apt.GoFiberSDKType,
 					ctx.Context(), 500,
 					ctx.Request().Body(), ctx.Response().Body(), respHeaders,
-					ctx.AllParams(), ctx.Route().Path,
+					ctx.AllParams(), matchedRoute(ctx, middlewareRoute),
 					config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
 					errorList,
 					msgID,
-					nil,
+					parentID,
 					string(ctx.Context().Referer()),
 					aptConfig,
 				)
+				payload.Duration = time.Since(start)
 				apt.CreateSpan(payload, aptConfig, span)
-				panic(err)
+				if !config.RecoverPanics {
+					panic(rec)
+				}
 			}
 		}()
 
-		err := ctx.Next()
+		err = ctx.Next()
+		if err != nil {
+			// The app's error handler is run here so the payload records the status it responds with.
+			// The error is handled once it has run, so it isn't returned to be handled again.
+			if handlerErr := ctx.App().ErrorHandler(ctx, err); handlerErr != nil {
+				_ = ctx.SendStatus(fiber.StatusInternalServerError)
+			}
+			apt.ReportHandlerError(ctx.UserContext(), err, ctx.Response().StatusCode(), config.IgnoreClientErrors)
+			err = nil
+		}
 		payload := apt.BuildFastHTTPPayload(apt.GoFiberSDKType,
 			ctx.Context(), ctx.Response().StatusCode(),
 			ctx.Request().Body(), ctx.Response().Body(), respHeaders,
-			ctx.AllParams(), ctx.Route().Path,
+			ctx.AllParams(), matchedRoute(ctx, middlewareRoute),
 			config.RedactHeaders, config.RedactRequestBody, config.RedactResponseBody,
 			errorList,
 			msgID,
-			nil,
+			parentID,
 			string(ctx.Context().Referer()),
 			aptConfig,
 		)
+		payload.Duration = time.Since(start)
 
 		apt.CreateSpan(payload, aptConfig, span)
 		return err
 	}
 }
 
+// matchedRoute returns the path template of the route which handled the request. It is empty when
+// no route after the middleware matched, rather than the prefix the middleware was registered with.
+func matchedRoute(ctx *fiber.Ctx, middlewareRoute *fiber.Route) string {
+	route := ctx.Route()
+	if route == middlewareRoute {
+		return ""
+	}
+	return route.Path
+}
+
 func ReportError(ctx context.Context, err error) {
 	apt.ReportError(ctx, err)
 }
 
+// ReportErrorWith reports an error with options such as its severity or additional context.
+var ReportErrorWith = apt.ReportErrorWith
+
+// Severity of a reported error
+type Severity = apt.Severity
+
+const (
+	SeverityWarning = apt.SeverityWarning
+	SeverityError   = apt.SeverityError
+	SeverityFatal   = apt.SeverityFatal
+)
+
+var WithSeverity = apt.WithSeverity
+var WithHandled = apt.WithHandled
+var WithErrorAttribute = apt.WithErrorAttribute
+var WithGroupingKey = apt.WithGroupingKey
+
+// AddBreadcrumb records an event on the current request, which is attached to the errors reported afterwards.
+var AddBreadcrumb = apt.AddBreadcrumb
+
+// SetErrorDeduplication limits how many errors with the same fingerprint are exported per time window.
+var SetErrorDeduplication = apt.SetErrorDeduplication
+
+// SetLogger redirects the SDK's diagnostics. Passing nil silences them.
+var SetLogger = apt.SetLogger
+
+// StartJob starts tracking a background job, whose errors are reported like a request's.
+var StartJob = apt.StartJob
+
+// CaptureJob runs a function as a background job, reporting its errors and panics.
+var CaptureJob = apt.CaptureJob
+
+// Go runs a function in a new goroutine as part of the current request, reporting its errors and panics.
+var Go = apt.Go
+
+// NewGroup returns an errgroup-like Group of goroutines run as part of the current request.
+var NewGroup = apt.NewGroup
+
+// Group runs goroutines as part of the current request and waits for them.
+type Group = apt.Group
+
 func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
-	opts = append([]otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}, opts...)
-	return otelconfig.ConfigureOpenTelemetry(opts...)
+	return apt.ConfigureOpenTelemetry(opts...)
+}
+
+// ConfigureOpenTelemetryLogs sets up the logs pipeline used by the slog handler's ExportLogs.
+func ConfigureOpenTelemetryLogs(opts ...otelconfig.Option) (func(), error) {
+	return apt.ConfigureOpenTelemetryLogs(opts...)
 }
 
 var WithServiceName = otelconfig.WithServiceName
@@ -120,6 +218,15 @@ var WithTracesEnabled = otelconfig.WithTracesEnabled
 var WithSpanProcessor = otelconfig.WithSpanProcessor
 var WithSampler = otelconfig.WithSampler
 
+// NewSlogHandler wraps a slog.Handler to tie log records to the current request.
+var NewSlogHandler = apt.NewSlogHandler
+
+// SlogHandlerOptions configures the handler returned by NewSlogHandler.
+type SlogHandlerOptions = apt.SlogHandlerOptions
+
+// PrometheusHandler serves the API metrics collected by the middleware in the Prometheus text format.
+var PrometheusHandler = apt.PrometheusHandler
+
 func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Client {
 	return apt.HTTPClient(ctx, opts...)
 }
@@ -127,3 +234,15 @@ func HTTPClient(ctx context.Context, opts ...apt.RoundTripperOption) *http.Clien
 var WithRedactHeaders = apt.WithRedactHeaders
 var WithRedactRequestBody = apt.WithRedactRequestBody
 var WithRedactResponseBody = apt.WithRedactResponseBody
+var WithCapturePolicy = apt.WithCapturePolicy
+var WithLegacySpanAttributes = apt.WithLegacySpanAttributes
+var WithMaxResponseBodyCapture = apt.WithMaxResponseBodyCapture
+
+// InstrumentDefaultTransport traces requests sent with http.DefaultClient and http.DefaultTransport.
+var InstrumentDefaultTransport = apt.InstrumentDefaultTransport
+
+// InstrumentDefaultClient traces requests sent with http.DefaultClient, leaving http.DefaultTransport unchanged.
+var InstrumentDefaultClient = apt.InstrumentDefaultClient
+
+// InstrumentClient makes an existing *http.Client, e.g. one of a third-party SDK, trace its requests.
+var InstrumentClient = apt.InstrumentClient
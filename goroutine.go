@@ -0,0 +1,153 @@
+package apitoolkit
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// goroutineSpanName is the name of the child spans created for goroutines
+const goroutineSpanName = "goroutine"
+
+// Go runs fn in a new goroutine, as part of the request or job ctx belongs to.
+// fn gets a child span of the request's span, and errors reported with its context
+// are attached to the request. Its returned error, or its panic, is reported too,
+// as a background job when the request has already finished, so it is never lost
+// and a panic doesn't crash the process.
+//
+// ctx is not canceled when the request finishes, since the goroutine may outlive it.
+//
+//	apitoolkit.Go(ctx, func(ctx context.Context) error {
+//		return sendConfirmationEmail(ctx, order)
+//	})
+func Go(ctx context.Context, fn func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	go runGoroutine(ctx, fn)
+}
+
+// runGoroutine runs fn with a child span, and reports its error or panic.
+func runGoroutine(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	tracer := otel.GetTracerProvider().Tracer(instrumentationName)
+	ctx, span := tracer.Start(ctx, goroutineSpanName, trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	defer func() {
+		outcome := JobOutcomeError
+		if rec := recover(); rec != nil {
+			err = NewPanicError(rec)
+			outcome = JobOutcomePanic
+		}
+		if err != nil {
+			reportGoroutineError(ctx, span, err, outcome)
+		}
+	}()
+	return fn(ctx)
+}
+
+func reportGoroutineError(ctx context.Context, span trace.Span, err error, outcome string) {
+	atError := BuildError(err)
+	recordExceptions(span, []ATError{atError})
+	span.SetStatus(codes.Error, err.Error())
+	if collector := ErrorCollectorFromContext(ctx); collector != nil && collector.Add(atError) {
+		return
+	}
+	// The request has finished, or there is none
+	_, job := StartJob(ctx, goroutineSpanName)
+	job.errorList.Add(atError)
+	job.end(outcome)
+}
+
+// Group runs goroutines like Go and waits for them, with the method set of errgroup.Group
+// so it can replace it. The first error cancels the context returned by NewGroup and is
+// returned by Wait. Each goroutine gets a child span of that context's request span, and its
+// error or panic is reported on the request. Unlike with Go, the context is canceled with its parent.
+// A zero Group is valid, its goroutines aren't part of a request.
+//
+//	g, ctx := apitoolkit.NewGroup(ctx)
+//	g.Go(func() error {
+//		return fetchUser(ctx, id)
+//	})
+//	err := g.Wait()
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup returns a Group and the context derived from ctx its goroutines should use,
+// like errgroup.WithContext.
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &Group{ctx: ctx, cancel: cancel}, ctx
+}
+
+// SetLimit limits the number of goroutines running at once, Go blocks until one can start.
+// A negative limit removes it. It must not be called while goroutines are running.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs fn in a new goroutine, blocking until the limit set by SetLimit allows it.
+func (g *Group) Go(fn func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.run(fn)
+}
+
+// TryGo runs fn in a new goroutine only when the limit set by SetLimit allows it, and reports whether it did.
+func (g *Group) TryGo(fn func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.run(fn)
+	return true
+}
+
+func (g *Group) run(fn func() error) {
+	ctx := g.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			if g.sem != nil {
+				<-g.sem
+			}
+			g.wg.Done()
+		}()
+		err := runGoroutine(ctx, func(context.Context) error { return fn() })
+		if err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				if g.cancel != nil {
+					g.cancel(err)
+				}
+			})
+		}
+	}()
+}
+
+// Wait waits for all the goroutines, and returns the first error, panics included.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel(g.err)
+	}
+	return g.err
+}
@@ -0,0 +1,81 @@
+package apitoolkit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+var instrumentMu sync.Mutex
+
+// InstrumentDefaultTransport traces the requests sent with http.DefaultTransport and
+// http.DefaultClient, which most third-party SDK clients use unless configured otherwise.
+// Each request is attached to the request or job of its own context.
+// It should be called at startup, before any request is sent, and returns a function
+// restoring the original transports.
+//
+// http.DefaultTransport is no longer an *http.Transport afterwards, so code asserting it is,
+// e.g. http.DefaultTransport.(*http.Transport).Clone(), panics. InstrumentDefaultClient
+// leaves http.DefaultTransport unchanged for such code.
+//
+//	restore := apitoolkit.InstrumentDefaultTransport()
+//	defer restore()
+func InstrumentDefaultTransport(opts ...RoundTripperOption) (restore func()) {
+	instrumentMu.Lock()
+	defer instrumentMu.Unlock()
+
+	origTransport, origClientTransport := http.DefaultTransport, http.DefaultClient.Transport
+	if _, ok := origTransport.(*roundTripper); !ok {
+		http.DefaultTransport = WrapRoundTripper(context.Background(), origTransport, opts...)
+	}
+	// A nil transport uses http.DefaultTransport, which is already instrumented
+	if origClientTransport != nil {
+		if _, ok := origClientTransport.(*roundTripper); !ok {
+			http.DefaultClient.Transport = WrapRoundTripper(context.Background(), origClientTransport, opts...)
+		}
+	}
+
+	return func() {
+		instrumentMu.Lock()
+		defer instrumentMu.Unlock()
+		http.DefaultTransport = origTransport
+		http.DefaultClient.Transport = origClientTransport
+	}
+}
+
+// InstrumentDefaultClient traces the requests sent with http.DefaultClient, like
+// InstrumentDefaultTransport but leaving http.DefaultTransport unchanged. It returns
+// a function restoring the client's original transport.
+func InstrumentDefaultClient(opts ...RoundTripperOption) (restore func()) {
+	instrumentMu.Lock()
+	defer instrumentMu.Unlock()
+
+	origClientTransport := http.DefaultClient.Transport
+	transport := origClientTransport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if _, ok := transport.(*roundTripper); !ok {
+		http.DefaultClient.Transport = WrapRoundTripper(context.Background(), transport, opts...)
+	}
+
+	return func() {
+		instrumentMu.Lock()
+		defer instrumentMu.Unlock()
+		http.DefaultClient.Transport = origClientTransport
+	}
+}
+
+// InstrumentClient makes client trace its requests, for clients created by third-party SDKs
+// which accept an *http.Client. Each request is attached to the request or job of its own context.
+// The client is modified in place, and is left unchanged when it is already instrumented.
+func InstrumentClient(client *http.Client, opts ...RoundTripperOption) {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if _, ok := transport.(*roundTripper); ok {
+		return
+	}
+	client.Transport = WrapRoundTripper(context.Background(), transport, opts...)
+}
@@ -0,0 +1,119 @@
+package apitoolkit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const GoJobSDKType = "GoJob"
+
+// Job outcomes recorded on the job span
+const (
+	JobOutcomeSuccess = "success"
+	JobOutcomeError   = "error"
+	JobOutcomePanic   = "panic"
+)
+
+// Job tracks a unit of background work such as a cron task, a queue consumer or a goroutine.
+// Like a request handled by the middlewares, it has its own span, message ID and error list,
+// so errors reported with its context are exported even though there is no HTTP request.
+type Job struct {
+	name      string
+	start     time.Time
+	span      trace.Span
+	msgID     uuid.UUID
+	errorList *ErrorCollector
+	endOnce   sync.Once
+}
+
+// StartJob starts a job with the given name. The returned context should be used for the
+// job's work, so errors reported and outgoing requests made with it are attached to the job.
+// Job.End must be called once the work is done.
+func StartJob(ctx context.Context, name string) (context.Context, *Job) {
+	tracer := otel.GetTracerProvider().Tracer(instrumentationName)
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindInternal))
+
+	job := &Job{
+		name:      name,
+		start:     time.Now(),
+		span:      span,
+		msgID:     uuid.Must(uuid.NewRandom()),
+		errorList: NewErrorCollector(),
+	}
+	ctx = context.WithValue(ctx, CurrentRequestMessageID, job.msgID)
+	ctx = context.WithValue(ctx, ErrorListCtxKey, job.errorList)
+	return ctx, job
+}
+
+// End finishes the job, recording err as its outcome when it is not nil, and exports the job span.
+// Calling End more than once has no effect.
+func (j *Job) End(err error) {
+	outcome := JobOutcomeSuccess
+	if err != nil {
+		// The stack starts at the caller of End, where the job finished
+		j.errorList.Add(buildError(err, 1))
+		outcome = JobOutcomeError
+	}
+	j.end(outcome)
+}
+
+func (j *Job) end(outcome string) {
+	j.endOnce.Do(func() {
+		defer j.span.End()
+		duration := time.Since(j.start)
+		errorList := j.errorList.Close()
+		exportedErrors, suppressedErrors := errorDedup.filter(errorList)
+		atErrors, _ := json.Marshal(exportedErrors)
+		j.span.SetAttributes(
+			attribute.String("apitoolkit.sdk_type", GoJobSDKType),
+			attribute.String("apitoolkit.job.name", j.name),
+			attribute.String("apitoolkit.job.outcome", outcome),
+			attribute.String("apitoolkit.msg_id", j.msgID.String()),
+			attribute.Int64("apitoolkit.duration_ns", duration.Nanoseconds()),
+			attribute.String("apitoolkit.errors", string(atErrors)),
+		)
+		recordExceptions(j.span, exportedErrors)
+		if suppressedErrors > 0 {
+			j.span.SetAttributes(attribute.Int("apitoolkit.errors_suppressed", suppressedErrors))
+		}
+		if breadcrumbs := j.errorList.Breadcrumbs(); len(breadcrumbs) > 0 && outcome != JobOutcomeSuccess {
+			breadcrumbsJSON, _ := json.Marshal(breadcrumbs)
+			j.span.SetAttributes(attribute.String("apitoolkit.breadcrumbs", string(breadcrumbsJSON)))
+		}
+		if outcome != JobOutcomeSuccess {
+			description := outcome
+			if len(errorList) > 0 {
+				description = errorList[len(errorList)-1].Message
+			}
+			j.span.SetStatus(codes.Error, description)
+		}
+	})
+}
+
+// CaptureJob runs fn as a job with the given name, and returns its error.
+// A panic in fn is recovered, reported and returned as an error, so it doesn't crash the process.
+//
+//	err := apitoolkit.CaptureJob(ctx, "send-daily-digest", func(ctx context.Context) error {
+//		return sendDigest(ctx)
+//	})
+func CaptureJob(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	ctx, job := StartJob(ctx, name)
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = NewPanicError(rec)
+			job.errorList.Add(BuildError(err))
+			job.end(JobOutcomePanic)
+		}
+	}()
+	err = fn(ctx)
+	job.End(err)
+	return err
+}
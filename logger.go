@@ -0,0 +1,60 @@
+package apitoolkit
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+var sdkLogger atomic.Pointer[slog.Logger]
+
+func init() {
+	// Diagnostics are only emitted at debug level when Config.Debug is set, so the default logger shows them
+	sdkLogger.Store(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+}
+
+// SetLogger redirects the SDK's own diagnostics, such as errors reported outside of a request
+// or the payloads logged in Debug mode. Passing nil silences them.
+func SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
+	sdkLogger.Store(logger)
+}
+
+// logger returns the logger configured for a middleware, falling back to the SDK logger.
+func (c Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return sdkLogger.Load()
+}
+
+// LogValue implements slog.LogValuer, so payloads are logged as a summary of the request
+// without their headers and bodies, which may hold sensitive data.
+func (p Payload) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("msg_id", p.MsgID),
+		slog.String("sdk_type", p.SdkType),
+		slog.String("method", p.Method),
+		slog.String("host", p.Host),
+		slog.String("route", p.URLPath),
+		slog.Int("status_code", p.StatusCode),
+		slog.Duration("duration", p.Duration),
+		slog.Int("request_body_size", p.RequestBodySize),
+		slog.Int("response_body_size", p.ResponseBodySize),
+		slog.Int("errors", len(p.Errors)),
+	}
+	if p.ParentID != nil {
+		attrs = append(attrs, slog.String("parent_id", *p.ParentID))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
@@ -0,0 +1,120 @@
+package apitoolkit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/apitoolkit/apitoolkit-go"
+
+// durationBuckets are the bucket boundaries recommended by the HTTP semantic conventions, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// httpMetrics holds the RED instruments for one side (server or client) of an HTTP exchange.
+type httpMetrics struct {
+	requestCount metric.Int64Counter
+	errorCount   metric.Int64Counter
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+var (
+	metricsOnce   sync.Once
+	serverMetrics *httpMetrics
+	clientMetrics *httpMetrics
+	// clientPhaseDuration breaks the latency of outgoing requests down by connection phase
+	clientPhaseDuration metric.Float64Histogram
+)
+
+// initMetrics creates the instruments from the global MeterProvider, which is
+// set up by ConfigureOpenTelemetry when metrics are enabled.
+func initMetrics() {
+	meter := otel.GetMeterProvider().Meter(instrumentationName)
+	serverMetrics = newHTTPMetrics(meter, "http.server")
+	clientMetrics = newHTTPMetrics(meter, "http.client")
+	clientPhaseDuration, _ = meter.Float64Histogram("http.client.phase.duration",
+		metric.WithDescription("Duration of the connection phases of outgoing HTTP requests: dns, connect, tls and time_to_first_byte."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+}
+
+func newHTTPMetrics(meter metric.Meter, prefix string) *httpMetrics {
+	m := &httpMetrics{}
+	// Instrument creation only fails on invalid names, and always returns a usable no-op instrument
+	m.requestCount, _ = meter.Int64Counter(prefix+".request.count",
+		metric.WithDescription("Number of HTTP requests."),
+		metric.WithUnit("{request}"))
+	m.errorCount, _ = meter.Int64Counter(prefix+".error.count",
+		metric.WithDescription("Number of errors reported while handling HTTP requests."),
+		metric.WithUnit("{error}"))
+	m.duration, _ = meter.Float64Histogram(prefix+".request.duration",
+		metric.WithDescription("Duration of HTTP requests."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	m.requestSize, _ = meter.Int64Histogram(prefix+".request.body.size",
+		metric.WithDescription("Size of HTTP request bodies."),
+		metric.WithUnit("By"))
+	m.responseSize, _ = meter.Int64Histogram(prefix+".response.body.size",
+		metric.WithDescription("Size of HTTP response bodies."),
+		metric.WithUnit("By"))
+	return m
+}
+
+// recordMetrics records the request count, latency and body sizes of a finished request,
+// keyed by route template (or upstream host for outgoing requests), method, status class and service version.
+func recordMetrics(ctx context.Context, payload Payload, config Config) {
+	metricsOnce.Do(initMetrics)
+
+	m := serverMetrics
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", payload.Method),
+		attribute.String("http.response.status_class", statusClass(payload.StatusCode)),
+		attribute.String("service.version", config.ServiceVersion),
+	}
+	if payload.SdkType == GoOutgoing {
+		m = clientMetrics
+		attrs = append(attrs, attribute.String("server.address", payload.Host))
+		if payload.TransportError != "" {
+			attrs = append(attrs, attribute.String("error.type", payload.TransportError))
+		}
+	} else if payload.URLPath != "" {
+		// Only route templates are bounded, requests without one share a series
+		attrs = append(attrs, attribute.String("http.route", payload.URLPath))
+	}
+	opt := metric.WithAttributeSet(attribute.NewSet(attrs...))
+
+	m.requestCount.Add(ctx, 1, opt)
+	if len(payload.Errors) > 0 {
+		m.errorCount.Add(ctx, int64(len(payload.Errors)), opt)
+	}
+	if payload.Duration > 0 {
+		m.duration.Record(ctx, payload.Duration.Seconds(), opt)
+	}
+	m.requestSize.Record(ctx, int64(payload.RequestBodySize), opt)
+	m.responseSize.Record(ctx, int64(payload.ResponseBodySize), opt)
+	if payload.Timing != nil {
+		for _, p := range payload.Timing.phases() {
+			phaseAttrs := append(attrs[:len(attrs):len(attrs)], attribute.String("http.client.phase", p.name))
+			clientPhaseDuration.Record(ctx, p.duration.Seconds(), metric.WithAttributeSet(attribute.NewSet(phaseAttrs...)))
+		}
+	}
+
+	if promEnabled.Load() {
+		promMetrics.record(payload, config)
+	}
+}
+
+// statusClass groups a status code into its class, e.g. 404 into "4xx".
+// It is empty for outgoing requests which failed without a response.
+func statusClass(statusCode int) string {
+	if statusCode == 0 {
+		return ""
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
@@ -0,0 +1,21 @@
+//go:build go1.23
+
+package apitoolkitnative
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeTemplate returns the path of the http.ServeMux pattern which matched req, without its
+// method and host, e.g. "/items/{id}" for "GET /items/{id}". It is empty for other handlers.
+func routeTemplate(req *http.Request) string {
+	pattern := req.Pattern
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
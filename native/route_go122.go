@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package apitoolkitnative
+
+import "net/http"
+
+// routeTemplate returns an empty route, as net/http doesn't expose the matched pattern before Go 1.23.
+func routeTemplate(req *http.Request) string {
+	return ""
+}
@@ -0,0 +1,117 @@
+package apitoolkit
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/honeycombio/otel-config-go/otelconfig"
+	"github.com/sethvargo/go-envconfig"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/log/global"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// ConfigureOpenTelemetry sets up the trace and metric pipelines, exporting to APItoolkit by default.
+// The logs pipeline used by the slog handler's ExportLogs is only set up as well when OTEL_LOGS_ENABLED=true,
+// otherwise ConfigureOpenTelemetryLogs sets it up.
+func ConfigureOpenTelemetry(opts ...otelconfig.Option) (func(), error) {
+	opts = append(defaultOtelOptions(), opts...)
+	shutdown, err := otelconfig.ConfigureOpenTelemetry(opts...)
+	if err != nil {
+		return shutdown, err
+	}
+	if enabled := os.Getenv("OTEL_LOGS_ENABLED"); !strings.EqualFold(enabled, "true") {
+		return shutdown, nil
+	}
+
+	shutdownLogs, err := setupLogs(opts...)
+	if err != nil {
+		return shutdown, err
+	}
+	return func() {
+		shutdownLogs()
+		shutdown()
+	}, nil
+}
+
+// ConfigureOpenTelemetryLogs sets up the logs pipeline used by the slog handler's ExportLogs,
+// with the same options as ConfigureOpenTelemetry.
+//
+//	shutdownLogs, err := apitoolkit.ConfigureOpenTelemetryLogs(apitoolkit.WithServiceName("api"))
+func ConfigureOpenTelemetryLogs(opts ...otelconfig.Option) (func(), error) {
+	return setupLogs(append(defaultOtelOptions(), opts...)...)
+}
+
+func defaultOtelOptions() []otelconfig.Option {
+	return []otelconfig.Option{otelconfig.WithExporterEndpoint("otelcol.apitoolkit.io:4317"), otelconfig.WithExporterInsecure(true)}
+}
+
+// setupLogs registers a global LoggerProvider exporting over OTLP, using the same
+// endpoint, headers and resource attributes as the otelconfig trace and metric pipelines.
+func setupLogs(opts ...otelconfig.Option) (func(), error) {
+	// Resolve the configuration the same way otelconfig does: options first, then the environment
+	c := &otelconfig.Config{
+		Headers:            map[string]string{},
+		TracesHeaders:      map[string]string{},
+		MetricsHeaders:     map[string]string{},
+		ResourceAttributes: map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	ctx := context.Background()
+	if err := envconfig.Process(ctx, c); err != nil {
+		return nil, err
+	}
+	if c.ExporterEndpoint == "" {
+		return func() {}, nil
+	}
+
+	var exporter sdklog.Exporter
+	var err error
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(c.ExporterEndpoint, "https://"), "http://")
+	if c.ExporterProtocol == otelconfig.ProtocolGRPC {
+		grpcOpts := []otlploggrpc.Option{otlploggrpc.WithEndpoint(endpoint), otlploggrpc.WithHeaders(c.Headers)}
+		if c.ExporterEndpointInsecure {
+			grpcOpts = append(grpcOpts, otlploggrpc.WithInsecure())
+		}
+		exporter, err = otlploggrpc.New(ctx, grpcOpts...)
+	} else {
+		httpOpts := []otlploghttp.Option{otlploghttp.WithEndpoint(endpoint), otlploghttp.WithHeaders(c.Headers)}
+		if c.ExporterEndpointInsecure {
+			httpOpts = append(httpOpts, otlploghttp.WithInsecure())
+		}
+		exporter, err = otlploghttp.New(ctx, httpOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{}
+	for k, v := range c.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	if c.ServiceName != "" {
+		attrs = append(attrs, attribute.String("service.name", c.ServiceName))
+	}
+	if c.ServiceVersion != "" {
+		attrs = append(attrs, attribute.String("service.version", c.ServiceVersion))
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(attrs...), resource.WithFromEnv(), resource.WithHost())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+	)
+	global.SetLoggerProvider(provider)
+	return func() {
+		provider.Shutdown(context.Background())
+	}, nil
+}
@@ -5,27 +5,55 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"time"
 
 	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type roundTripper struct {
 	base http.RoundTripper
-	ctx  context.Context
-	cfg  *roundTripperConfig
+	// ctx is the context given at construction, only used for requests made without a request context
+	ctx context.Context
+	cfg *roundTripperConfig
+}
+
+// parentContext returns the context an outgoing request is attached to: the request's own
+// context when it belongs to a request, a job or a span, so a client created once at startup
+// attributes each call correctly, and the construction context otherwise.
+func (rt *roundTripper) parentContext(req *http.Request) context.Context {
+	ctx := req.Context()
+	if rt.ctx == nil || trace.SpanContextFromContext(ctx).IsValid() ||
+		ctx.Value(CurrentRequestMessageID) != nil || ErrorCollectorFromContext(ctx) != nil {
+		return ctx
+	}
+	return rt.ctx
 }
 
 func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
-	defer func() {
-		if err != nil {
-			ReportError(rt.ctx, err)
-		}
-	}()
+	parentCtx := rt.parentContext(req)
 
+	start := time.Now()
 	tracer := otel.GetTracerProvider().Tracer("")
-	_, span := tracer.Start(rt.ctx, "apitoolkit-http-span", trace.WithSpanKind(trace.SpanKindClient))
+	spanCtx, span := tracer.Start(parentCtx, req.Method, trace.WithSpanKind(trace.SpanKindClient))
+
+	var parentMsgIDPtr *uuid.UUID
+	parentMsgID, ok := parentCtx.Value(CurrentRequestMessageID).(uuid.UUID)
+	if ok {
+		parentMsgIDPtr = &parentMsgID
+	}
+
+	// Propagate the trace context and the current request's message ID downstream.
+	// The request is cloned since a RoundTripper must not modify the request it was given.
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(spanCtx, propagation.HeaderCarrier(req.Header))
+	if parentMsgIDPtr != nil {
+		req.Header.Set(TraceParentIDHeader, parentMsgID.String())
+	}
 
 	// Capture the request body
 	reqBodyBytes := []byte{}
@@ -34,30 +62,31 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 		req.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
 	}
 
-	// Add a header to all outgoing requests "X-APITOOLKIT-TRACE-PARENT-ID"
+	// Time the connection phases, alongside any ClientTrace already set by the caller
+	timing := newTimingRecorder(start)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timing.clientTrace()))
+
 	res, err = rt.base.RoundTrip(req)
-	var errorList []ATError
+	errorList := NewErrorCollector()
+	var transportError string
 	if err != nil {
-		// Add the error for the given request payload
-		errorList = append(errorList, BuildError(err))
-	}
-
-	var payload Payload
-	var parentMsgIDPtr *uuid.UUID
-	parentMsgID, ok := rt.ctx.Value(CurrentRequestMessageID).(uuid.UUID)
-	if ok {
-		parentMsgIDPtr = &parentMsgID
+		// Add the error for the given request payload, typed with its category
+		transportError = ClassifyTransportError(err)
+		atError := BuildError(err)
+		withErrorType(transportError)(&atError)
+		errorList.Add(atError)
+		span.SetStatus(codes.Error, err.Error())
 	}
 
-	// Capture the response body
 	conf := roundTripperConfigToConfig(rt.cfg)
-	if res != nil {
-		respBodyBytes, _ := io.ReadAll(res.Body)
-		res.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes))
-		payload = BuildPayload(
+	// Leave a breadcrumb on the current request, the query string is left out as it may hold secrets
+	breadcrumbMessage := req.Method + " " + req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	if err != nil {
+		// There is no response, so no status code rather than a made up one
+		payload := BuildPayload(
 			GoOutgoing,
-			req, res.StatusCode, reqBodyBytes,
-			respBodyBytes, res.Header, nil,
+			req, 0, reqBodyBytes,
+			nil, nil, nil,
 			req.URL.Path,
 			rt.cfg.RedactHeaders, rt.cfg.RedactRequestBody, rt.cfg.RedactResponseBody,
 			errorList,
@@ -65,13 +94,31 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 			parentMsgIDPtr,
 			conf,
 		)
+		payload.Duration = time.Since(start)
+		payload.Timing = timing.result()
+		payload.TransportError = transportError
 		CreateSpan(payload, conf, span)
+		AddBreadcrumb(parentCtx, "http", breadcrumbMessage, map[string]any{
+			"error_type":  transportError,
+			"duration_ms": payload.Duration.Milliseconds(),
+			"error":       err.Error(),
+		})
+		// The failure is already on the client span, it is only reported on the parent request or job
+		// when there is one, rather than logged for every failed request of an instrumented default client
+		if ErrorCollectorFromContext(parentCtx) != nil || trace.SpanFromContext(parentCtx).IsRecording() {
+			ReportErrorWith(parentCtx, err, withErrorType(transportError))
+		}
+		return res, err
+	}
 
-	} else {
-		payload = BuildPayload(
+	// The span is finished once the caller is done with the response body,
+	// so a streamed or long-polling response is neither buffered nor blocked on
+	statusCode, respHeader := res.StatusCode, res.Header.Clone()
+	finish := func(respBody []byte, respBodySize int) {
+		payload := BuildPayload(
 			GoOutgoing,
-			req, 503, reqBodyBytes,
-			nil, nil, nil,
+			req, statusCode, reqBodyBytes,
+			respBody, respHeader, nil,
 			req.URL.Path,
 			rt.cfg.RedactHeaders, rt.cfg.RedactRequestBody, rt.cfg.RedactResponseBody,
 			errorList,
@@ -79,12 +126,28 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (res *http.Response, err er
 			parentMsgIDPtr,
 			conf,
 		)
+		payload.ResponseBodySize = respBodySize
+		payload.Duration = time.Since(start)
+		payload.Timing = timing.result()
 		CreateSpan(payload, conf, span)
-
 	}
+	// A 101 response body is the upgraded connection, which must stay an io.ReadWriteCloser
+	if res.Body == nil || res.Body == http.NoBody || statusCode == http.StatusSwitchingProtocols {
+		finish(nil, 0)
+	} else {
+		res.Body = newCapturingBody(res.Body, rt.cfg.maxResponseBodyCapture(), finish)
+	}
+
+	AddBreadcrumb(parentCtx, "http", breadcrumbMessage, map[string]any{
+		"status_code": statusCode,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
 	return res, err
 }
 
+// HTTPClient returns a client which traces its requests. Each request is attached to the
+// request or job of its own context, e.g. set with http.NewRequestWithContext, and to ctx
+// when it has none.
 func HTTPClient(ctx context.Context, opts ...RoundTripperOption) *http.Client {
 	// Run the roundTripperConfig to extract out a httpClient Transport
 	cfg := new(roundTripperConfig)
@@ -108,10 +171,24 @@ func HTTPClient(ctx context.Context, opts ...RoundTripperOption) *http.Client {
 }
 
 type roundTripperConfig struct {
-	HTTPClient         *http.Client
-	RedactHeaders      []string
-	RedactRequestBody  []string
-	RedactResponseBody []string
+	HTTPClient           *http.Client
+	RedactHeaders        []string
+	RedactRequestBody    []string
+	RedactResponseBody   []string
+	CapturePolicy        *CapturePolicy
+	LegacySpanAttributes bool
+	// MaxResponseBodyCapture is the number of bytes of response bodies kept, see WithMaxResponseBodyCapture
+	MaxResponseBodyCapture int
+}
+
+// defaultMaxResponseBodyCapture is the number of bytes of outgoing response bodies kept by default
+const defaultMaxResponseBodyCapture = 1 << 20
+
+func (rc *roundTripperConfig) maxResponseBodyCapture() int {
+	if rc.MaxResponseBodyCapture > 0 {
+		return rc.MaxResponseBodyCapture
+	}
+	return defaultMaxResponseBodyCapture
 }
 
 type RoundTripperOption func(*roundTripperConfig)
@@ -141,8 +218,31 @@ func WithRedactResponseBody(fields ...string) RoundTripperOption {
 	}
 }
 
+// WithCapturePolicy only keeps outgoing request and response bodies for calls matching the policy
+func WithCapturePolicy(policy *CapturePolicy) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.CapturePolicy = policy
+	}
+}
+
+// WithMaxResponseBodyCapture sets the number of bytes of response bodies kept for the payload, 1MiB by default.
+// The caller still reads the whole body, the rest is only counted in the body size.
+func WithMaxResponseBodyCapture(n int) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.MaxResponseBodyCapture = n
+	}
+}
+
+// WithLegacySpanAttributes additionally emits the pre-semconv span attributes on outgoing spans
+func WithLegacySpanAttributes(enabled bool) RoundTripperOption {
+	return func(rc *roundTripperConfig) {
+		rc.LegacySpanAttributes = enabled
+	}
+}
+
 // WrapRoundTripper returns a new RoundTripper which traces all requests sent
-// over the transport.
+// over the transport. Like with HTTPClient, ctx is only used for requests whose
+// context doesn't belong to a request or job.
 func WrapRoundTripper(ctx context.Context, rt http.RoundTripper, opts ...RoundTripperOption) http.RoundTripper {
 	cfg := new(roundTripperConfig)
 	for _, opt := range opts {
@@ -153,6 +253,11 @@ func WrapRoundTripper(ctx context.Context, rt http.RoundTripper, opts ...RoundTr
 	if rt == nil {
 		rt = http.DefaultTransport
 	}
+	// Rewrap an instrumented transport, such as the default one after InstrumentDefaultTransport,
+	// rather than tracing each request twice
+	if wrapped, ok := rt.(*roundTripper); ok {
+		rt = wrapped.base
+	}
 	return &roundTripper{
 		base: rt,
 		ctx:  ctx,
@@ -162,10 +267,12 @@ func WrapRoundTripper(ctx context.Context, rt http.RoundTripper, opts ...RoundTr
 
 func roundTripperConfigToConfig(cfg *roundTripperConfig) Config {
 	return Config{
-		RedactHeaders:       cfg.RedactHeaders,
-		RedactRequestBody:   cfg.RedactRequestBody,
-		RedactResponseBody:  cfg.RedactResponseBody,
-		CaptureRequestBody:  true,
-		CaptureResponseBody: true,
+		RedactHeaders:        cfg.RedactHeaders,
+		RedactRequestBody:    cfg.RedactRequestBody,
+		RedactResponseBody:   cfg.RedactResponseBody,
+		CaptureRequestBody:   true,
+		CaptureResponseBody:  true,
+		CapturePolicy:        cfg.CapturePolicy,
+		LegacySpanAttributes: cfg.LegacySpanAttributes,
 	}
 }
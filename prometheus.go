@@ -0,0 +1,220 @@
+package apitoolkit
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// promEnabled is switched on by PrometheusHandler, so that nothing is aggregated
+// in process unless the metrics are actually exposed.
+var promEnabled atomic.Bool
+
+var promMetrics = newPromRegistry()
+
+// maxPromSeries bounds the series of each metric, so the registry can't grow without limit
+const maxPromSeries = 2000
+
+// PrometheusHandler returns a http.Handler serving the API metrics observed by the middlewares
+// and outgoing clients in the Prometheus text exposition format. The metrics are aggregated
+// in process, so they remain available when the OpenTelemetry collector is unreachable.
+//
+//	http.Handle("/metrics", apitoolkit.PrometheusHandler())
+func PrometheusHandler() http.Handler {
+	promEnabled.Store(true)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(promMetrics.render())
+	})
+}
+
+// promSeries is a single labelled time series of a counter or histogram.
+type promSeries struct {
+	labels []string // alternating label names and values
+	value  float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type promMetric struct {
+	name    string
+	help    string
+	buckets []float64 // nil for counters
+	series  map[string]*promSeries
+}
+
+type promRegistry struct {
+	mu      sync.Mutex
+	metrics []*promMetric
+	byName  map[string]*promMetric
+}
+
+func newPromRegistry() *promRegistry {
+	r := &promRegistry{byName: map[string]*promMetric{}}
+	r.register("apitoolkit_http_server_requests_total", "Number of incoming HTTP requests.", nil)
+	r.register("apitoolkit_http_server_errors_total", "Number of errors reported while handling incoming HTTP requests.", nil)
+	r.register("apitoolkit_http_server_request_duration_seconds", "Duration of incoming HTTP requests.", durationBuckets)
+	r.register("apitoolkit_http_client_requests_total", "Number of outgoing HTTP requests.", nil)
+	r.register("apitoolkit_http_client_errors_total", "Number of errors reported by outgoing HTTP requests.", nil)
+	r.register("apitoolkit_http_client_request_duration_seconds", "Duration of outgoing HTTP requests.", durationBuckets)
+	r.register("apitoolkit_http_client_phase_duration_seconds", "Duration of the connection phases of outgoing HTTP requests.", durationBuckets)
+	return r
+}
+
+func (r *promRegistry) register(name, help string, buckets []float64) {
+	m := &promMetric{name: name, help: help, buckets: buckets, series: map[string]*promSeries{}}
+	r.metrics = append(r.metrics, m)
+	r.byName[name] = m
+}
+
+// get returns the series of the named metric for the given labels, creating it when needed.
+// The registry lock must be held.
+func (r *promRegistry) get(name string, labels []string) *promSeries {
+	m := r.byName[name]
+	key := formatPromLabels(labels, "", "")
+	s, ok := m.series[key]
+	if !ok && len(m.series) >= maxPromSeries {
+		// Past the cap, new routes and hosts are aggregated without their route or host label
+		labels = withoutUnboundedLabels(labels)
+		key = formatPromLabels(labels, "", "")
+		s, ok = m.series[key]
+	}
+	if !ok {
+		s = &promSeries{labels: labels}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// withoutUnboundedLabels drops the labels whose values aren't known in advance.
+func withoutUnboundedLabels(labels []string) []string {
+	bounded := make([]string, 0, len(labels))
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i] != "route" && labels[i] != "host" {
+			bounded = append(bounded, labels[i], labels[i+1])
+		}
+	}
+	return bounded
+}
+
+func (r *promRegistry) add(name string, labels []string, v float64) {
+	r.get(name, labels).value += v
+}
+
+func (r *promRegistry) observe(name string, labels []string, v float64) {
+	s := r.get(name, labels)
+	for i, bound := range r.byName[name].buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// record aggregates a finished request into the Prometheus metrics.
+func (r *promRegistry) record(payload Payload, config Config) {
+	side := "server"
+	labels := []string{"method", payload.Method, "status_class", statusClass(payload.StatusCode), "service_version", config.ServiceVersion}
+	if payload.SdkType == GoOutgoing {
+		side = "client"
+		labels = append(labels, "host", payload.Host)
+		if payload.TransportError != "" {
+			labels = append(labels, "error_type", payload.TransportError)
+		}
+	} else if payload.URLPath != "" {
+		labels = append(labels, "route", payload.URLPath)
+	}
+	prefix := "apitoolkit_http_" + side
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(prefix+"_requests_total", labels, 1)
+	if len(payload.Errors) > 0 {
+		r.add(prefix+"_errors_total", labels, float64(len(payload.Errors)))
+	}
+	if payload.Duration > 0 {
+		r.observe(prefix+"_request_duration_seconds", labels, payload.Duration.Seconds())
+	}
+	if payload.Timing != nil {
+		for _, p := range payload.Timing.phases() {
+			phaseLabels := append(labels[:len(labels):len(labels)], "phase", p.name)
+			r.observe(prefix+"_phase_duration_seconds", phaseLabels, p.duration.Seconds())
+		}
+	}
+}
+
+func (r *promRegistry) render() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range r.metrics {
+		if len(m.series) == 0 {
+			continue
+		}
+		kind := "counter"
+		if m.buckets != nil {
+			kind = "histogram"
+		}
+		buf.WriteString("# HELP " + m.name + " " + m.help + "\n")
+		buf.WriteString("# TYPE " + m.name + " " + kind + "\n")
+
+		keys := make([]string, 0, len(m.series))
+		for k := range m.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := m.series[k]
+			if m.buckets == nil {
+				buf.WriteString(m.name + k + " " + formatPromFloat(s.value) + "\n")
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range m.buckets {
+				cumulative += s.counts[i]
+				buf.WriteString(m.name + "_bucket" + formatPromLabels(s.labels, "le", formatPromFloat(bound)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+			}
+			buf.WriteString(m.name + "_bucket" + formatPromLabels(s.labels, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+			buf.WriteString(m.name + "_sum" + k + " " + formatPromFloat(s.sum) + "\n")
+			buf.WriteString(m.name + "_count" + k + " " + strconv.FormatUint(s.count, 10) + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// formatPromLabels renders labels as {name="value",...}, with an optional extra label appended.
+func formatPromLabels(labels []string, extraName, extraValue string) string {
+	if extraName != "" {
+		labels = append(labels[:len(labels):len(labels)], extraName, extraValue)
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(labels[i] + `="` + promLabelEscaper.Replace(labels[i+1]) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatPromFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,33 @@
+package apitoolkit
+
+import (
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// FastHTTPHeaderCarrier adapts fasthttp request headers to the OpenTelemetry
+// TextMapCarrier interface, so trace context can be propagated through them.
+type FastHTTPHeaderCarrier struct {
+	Header *fasthttp.RequestHeader
+}
+
+var _ propagation.TextMapCarrier = FastHTTPHeaderCarrier{}
+
+// Get returns the value associated with the passed key.
+func (c FastHTTPHeaderCarrier) Get(key string) string {
+	return string(c.Header.Peek(key))
+}
+
+// Set stores the key-value pair.
+func (c FastHTTPHeaderCarrier) Set(key string, value string) {
+	c.Header.Set(key, value)
+}
+
+// Keys lists the keys stored in this carrier.
+func (c FastHTTPHeaderCarrier) Keys() []string {
+	keys := []string{}
+	c.Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
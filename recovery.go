@@ -0,0 +1,121 @@
+package apitoolkit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PanicError is the error reported for a recovered panic.
+// It holds the panic value, which may be of any type, and the stack at the panic site.
+type PanicError struct {
+	Value any
+	Stack []byte
+
+	callers []uintptr
+}
+
+// NewPanicError wraps a recovered panic value. It must be called from the deferred
+// function which recovered the panic, so the captured stack still includes the panic site.
+func NewPanicError(value any) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack(), callers: callers(1)}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value when the handler panicked with an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// RecoverPanic records a panic recovered by a middleware: it is reported as an ATError with
+// ErrorType "panic" on the request's error list, and the span is marked as failed.
+// It must be called directly from the deferred function which called recover().
+//
+// The middleware then finishes the span as usual and, depending on Config.RecoverPanics,
+// either re-panics with the original value or writes Config.PanicResponse with a 500 status.
+func RecoverPanic(ctx context.Context, span trace.Span, value any) *PanicError {
+	panicErr := NewPanicError(value)
+	if collector := ErrorCollectorFromContext(ctx); collector != nil {
+		collector.Add(BuildError(panicErr))
+	}
+	span.SetStatus(codes.Error, panicErr.Error())
+	return panicErr
+}
+
+// PanicResponse returns the body written with a 500 status when a panic is recovered.
+func (c Config) PanicResponse() []byte {
+	if c.PanicResponseBody != nil {
+		return c.PanicResponseBody
+	}
+	return []byte(http.StatusText(http.StatusInternalServerError))
+}
+
+// RecordedResponse is the response of a handler served by ServeRecorded.
+type RecordedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Panic is set when the handler panicked, the status code is then 500
+	Panic *PanicError
+}
+
+// ServeRecorded serves req with next into a recorder, so net/http middlewares can read the response,
+// and then writes it to w. A panic in next is recorded with RecoverPanic, and when config.RecoverPanics
+// is set Config.PanicResponse is written with a 500 status instead. http.ErrAbortHandler is
+// re-panicked right away, as it aborts the response on purpose and isn't an error.
+//
+// Once the span is finished, the middleware calls RecordedResponse.Repanic.
+func ServeRecorded(w http.ResponseWriter, req *http.Request, next http.Handler, span trace.Span, config Config) RecordedResponse {
+	rec := httptest.NewRecorder()
+	var panicErr *PanicError
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				panicErr = RecoverPanic(req.Context(), span, v)
+			}
+		}()
+		next.ServeHTTP(rec, req)
+	}()
+
+	recRes := rec.Result()
+	body, _ := io.ReadAll(recRes.Body)
+	res := RecordedResponse{StatusCode: recRes.StatusCode, Header: recRes.Header, Body: body, Panic: panicErr}
+	if panicErr == nil {
+		for k, v := range recRes.Header {
+			for _, vv := range v {
+				w.Header().Add(k, vv)
+			}
+		}
+		w.WriteHeader(res.StatusCode)
+		w.Write(body)
+		return res
+	}
+	res.StatusCode = http.StatusInternalServerError
+	if config.RecoverPanics {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(res.StatusCode)
+		w.Write(config.PanicResponse())
+	}
+	return res
+}
+
+// Repanic panics again with the original value when the handler panicked and
+// config.RecoverPanics isn't set, so the panic reaches the usual handlers.
+func (r RecordedResponse) Repanic(config Config) {
+	if r.Panic != nil && !config.RecoverPanics {
+		panic(r.Panic.Value)
+	}
+}
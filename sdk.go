@@ -1,16 +1,21 @@
 package apitoolkit
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AsaiYusuke/jsonpath"
 	"github.com/google/uuid"
 	"github.com/valyala/fasthttp"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -20,8 +25,13 @@ const (
 	GoGorillaMux     = "GoGorillaMux"
 	GoOutgoing       = "GoOutgoing"
 	GoFiberSDKType   = "GoFiber"
+	GoEchoSDKType    = "GoEcho"
 )
 
+// TraceParentIDHeader carries the message ID of the request which made an outgoing call,
+// so the downstream request can be linked to it as its parent.
+const TraceParentIDHeader = "X-APITOOLKIT-TRACE-PARENT-ID"
+
 type ctxKey string
 
 var (
@@ -32,28 +42,35 @@ var (
 )
 
 // Payload represents request and response details
-// FIXME: How would we handle errors from background processes (Not web requests)
 type Payload struct {
-	RequestHeaders  map[string][]string `json:"request_headers"`
-	QueryParams     map[string][]string `json:"query_params"`
-	PathParams      map[string]string   `json:"path_params"`
-	ResponseHeaders map[string][]string `json:"response_headers"`
-	Method          string              `json:"method"`
-	SdkType         string              `json:"sdk_type"`
-	Host            string              `json:"host"`
-	RawURL          string              `json:"raw_url"`
-	Referer         string              `json:"referer"`
-	URLPath         string              `json:"url_path"`
-	ResponseBody    []byte              `json:"response_body"`
-	RequestBody     []byte              `json:"request_body"`
-	ProtoMinor      int                 `json:"proto_minor"`
-	StatusCode      int                 `json:"status_code"`
-	ProtoMajor      int                 `json:"proto_major"`
-	Errors          []ATError           `json:"errors"`
-	ServiceVersion  *string             `json:"service_version"`
-	Tags            []string            `json:"tags"`
-	MsgID           string              `json:"msg_id"`
-	ParentID        *string             `json:"parent_id"`
+	RequestHeaders   map[string][]string `json:"request_headers"`
+	QueryParams      map[string][]string `json:"query_params"`
+	PathParams       map[string]string   `json:"path_params"`
+	ResponseHeaders  map[string][]string `json:"response_headers"`
+	Method           string              `json:"method"`
+	SdkType          string              `json:"sdk_type"`
+	Host             string              `json:"host"`
+	RawURL           string              `json:"raw_url"`
+	Referer          string              `json:"referer"`
+	URLPath          string              `json:"url_path"`
+	ResponseBody     []byte              `json:"response_body"`
+	RequestBody      []byte              `json:"request_body"`
+	ProtoMinor       int                 `json:"proto_minor"`
+	StatusCode       int                 `json:"status_code"`
+	ProtoMajor       int                 `json:"proto_major"`
+	Errors           []ATError           `json:"errors"`
+	Breadcrumbs      []Breadcrumb        `json:"breadcrumbs,omitempty"`
+	ServiceVersion   *string             `json:"service_version"`
+	Tags             []string            `json:"tags"`
+	MsgID            string              `json:"msg_id"`
+	ParentID         *string             `json:"parent_id"`
+	ClientAddress    string              `json:"client_address"`
+	UserAgent        string              `json:"user_agent"`
+	Duration         time.Duration       `json:"duration"`
+	RequestBodySize  int                 `json:"request_body_size"`
+	ResponseBodySize int                 `json:"response_body_size"`
+	Timing           *ConnectionTiming   `json:"timing,omitempty"`
+	TransportError   string              `json:"transport_error,omitempty"`
 }
 
 type Config struct {
@@ -66,12 +83,30 @@ type Config struct {
 	Tags                []string
 	CaptureRequestBody  bool
 	CaptureResponseBody bool
+	// CapturePolicy optionally restricts body capture to matching requests.
+	// Bodies are captured for every request when it is nil.
+	CapturePolicy *CapturePolicy
+	// LegacySpanAttributes additionally emits the pre-semconv span attributes
+	// (net.host.name, http.target, http.request.query_params) and original-case header keys.
+	LegacySpanAttributes bool
+	// Logger receives the middleware's diagnostics. Defaults to the logger set with SetLogger.
+	Logger *slog.Logger
+	// RecoverPanics writes a 500 response after recording a panic, instead of re-panicking.
+	RecoverPanics bool
+	// PanicResponseBody is the body of that 500 response, "Internal Server Error" by default.
+	PanicResponseBody []byte
 }
 
 func CreateSpan(payload Payload, config Config, span trace.Span) {
 	defer span.End()
-	atErrors, _ := json.Marshal(payload.Errors)
-	queryParams, _ := json.Marshal(payload.QueryParams)
+	if config.Debug {
+		config.logger().Debug("APIToolkit: request captured", "payload", payload)
+	}
+	span.SetName(spanName(payload))
+
+	// Repeated errors are dropped from the span, but are still counted in the metrics
+	exportedErrors, suppressedErrors := errorDedup.filter(payload.Errors)
+	atErrors, _ := json.Marshal(exportedErrors)
 	pathParams, _ := json.Marshal(payload.PathParams)
 	requestBody := []byte{}
 	if config.CaptureRequestBody {
@@ -81,14 +116,12 @@ func CreateSpan(payload Payload, config Config, span trace.Span) {
 	if config.CaptureResponseBody {
 		responseBody = payload.ResponseBody
 	}
+	urlPath, urlQuery, _ := strings.Cut(payload.RawURL, "?")
 	attrs := []attribute.KeyValue{
 		attribute.String("apitoolkit.service_version", config.ServiceVersion),
-		attribute.String("net.host.name", payload.Host),
-		attribute.String("http.route", payload.URLPath),
 		attribute.String("http.request.method", payload.Method),
-		attribute.Int("http.response.status_code", payload.StatusCode),
-		attribute.String("http.request.query_params", string(queryParams)),
-		attribute.String("http.target", payload.RawURL),
+		attribute.String("url.path", urlPath),
+		attribute.String("network.protocol.version", protocolVersion(payload.ProtoMajor, payload.ProtoMinor)),
 		attribute.String("http.request.path_params", string(pathParams)),
 		attribute.String("apitoolkit.sdk_type", payload.SdkType),
 		attribute.String("http.request.body", base64.StdEncoding.EncodeToString(requestBody)),
@@ -96,20 +129,112 @@ func CreateSpan(payload Payload, config Config, span trace.Span) {
 		attribute.String("apitoolkit.errors", string(atErrors)),
 		attribute.StringSlice("apitoolkit.tags", payload.Tags),
 	}
+	// http.route is only defined for server spans with a known route template, outgoing requests have none
+	if payload.URLPath != "" && (payload.SdkType != GoOutgoing || config.LegacySpanAttributes) {
+		attrs = append(attrs, attribute.String("http.route", payload.URLPath))
+	}
+	// Outgoing requests which failed without a response have no status code, but an error.type
+	if payload.StatusCode != 0 {
+		attrs = append(attrs, attribute.Int("http.response.status_code", payload.StatusCode))
+	}
+	if payload.TransportError != "" {
+		attrs = append(attrs, attribute.String("error.type", payload.TransportError))
+	}
+	if urlQuery != "" {
+		attrs = append(attrs, attribute.String("url.query", urlQuery))
+	}
+	if host, port, err := net.SplitHostPort(payload.Host); err == nil {
+		attrs = append(attrs, attribute.String("server.address", host))
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, attribute.Int("server.port", p))
+		}
+	} else if payload.Host != "" {
+		attrs = append(attrs, attribute.String("server.address", payload.Host))
+	}
+	if payload.ClientAddress != "" {
+		attrs = append(attrs, attribute.String("client.address", payload.ClientAddress))
+	}
+	if payload.UserAgent != "" {
+		attrs = append(attrs, attribute.String("user_agent.original", payload.UserAgent))
+	}
+	if payload.Timing != nil {
+		attrs = append(attrs, payload.Timing.attributes()...)
+	}
+	if config.LegacySpanAttributes {
+		queryParams, _ := json.Marshal(payload.QueryParams)
+		attrs = append(attrs,
+			attribute.String("net.host.name", payload.Host),
+			attribute.String("http.target", payload.RawURL),
+			attribute.String("http.request.query_params", string(queryParams)),
+		)
+	}
 	span.SetAttributes(attrs...)
 
-	for key, value := range payload.RequestHeaders {
-		span.SetAttributes(attribute.KeyValue{Key: attribute.Key("http.request.header." + key), Value: attribute.StringSliceValue(value)})
+	setHeaderAttributes(span, "http.request.header.", payload.RequestHeaders, config.LegacySpanAttributes)
+	setHeaderAttributes(span, "http.response.header.", payload.ResponseHeaders, config.LegacySpanAttributes)
+	if payload.MsgID != "" {
+		span.SetAttributes(attribute.String("apitoolkit.msg_id", payload.MsgID))
 	}
+	if payload.ParentID != nil {
+		span.SetAttributes(attribute.String("apitoolkit.parent_id", *payload.ParentID))
+	}
+	if payload.Duration > 0 {
+		span.SetAttributes(attribute.Int64("apitoolkit.duration_ns", payload.Duration.Nanoseconds()))
+	}
+	recordMetrics(trace.ContextWithSpan(context.Background(), span), payload, config)
 
-	for key, value := range payload.ResponseHeaders {
-		span.SetAttributes(attribute.KeyValue{Key: attribute.Key("http.response.header." + key), Value: attribute.StringSliceValue(value)})
+	recordExceptions(span, exportedErrors)
+	if suppressedErrors > 0 {
+		span.SetAttributes(attribute.Int("apitoolkit.errors_suppressed", suppressedErrors))
 	}
-	if payload.MsgID != "" {
-		span.SetAttributes(attribute.String("apitoolkit.msg_id", payload.MsgID))
+	// Breadcrumbs are only useful to investigate failed requests
+	if len(payload.Breadcrumbs) > 0 && (payload.StatusCode >= 500 || len(payload.Errors) > 0) {
+		breadcrumbs, _ := json.Marshal(payload.Breadcrumbs)
+		span.SetAttributes(attribute.String("apitoolkit.breadcrumbs", string(breadcrumbs)))
+	}
+	if payload.StatusCode >= 500 {
+		// A panic is what failed the request, otherwise the last error reported is the closest to the failure
+		description := http.StatusText(payload.StatusCode)
+		for _, atError := range payload.Errors {
+			description = atError.Message
+			if atError.ErrorType == "panic" {
+				break
+			}
+		}
+		span.SetStatus(codes.Error, description)
+	}
+}
 
+// spanName follows the HTTP semantic conventions: "METHOD /route/template" for server spans,
+// and just the method for client spans or when no route is known.
+func spanName(payload Payload) string {
+	method := payload.Method
+	if method == "" {
+		method = "HTTP"
 	}
+	if payload.SdkType == GoOutgoing || payload.URLPath == "" {
+		return method
+	}
+	return method + " " + payload.URLPath
+}
+
+// protocolVersion formats the HTTP version as expected by network.protocol.version, e.g. "1.1" or "2".
+func protocolVersion(major, minor int) string {
+	if major >= 2 && minor == 0 {
+		return strconv.Itoa(major)
+	}
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor)
+}
 
+// setHeaderAttributes records headers under lowercased attribute keys.
+// The original header casing is kept as well when legacy attributes are enabled.
+func setHeaderAttributes(span trace.Span, prefix string, headers map[string][]string, legacy bool) {
+	for key, value := range headers {
+		span.SetAttributes(attribute.StringSlice(prefix+strings.ToLower(key), value))
+		if legacy && strings.ToLower(key) != key {
+			span.SetAttributes(attribute.StringSlice(prefix+key, value))
+		}
+	}
 }
 
 func RedactJSON(data []byte, redactList []string) []byte {
@@ -132,6 +257,14 @@ func RedactJSON(data []byte, redactList []string) []byte {
 	return dataJSON
 }
 
+// redactBody redacts a captured body, leaving bodies which were not kept as nil.
+func redactBody(body []byte, redactList []string) []byte {
+	if body == nil {
+		return nil
+	}
+	return RedactJSON(body, redactList)
+}
+
 func RedactHeaders(headers map[string][]string, redactList []string) map[string][]string {
 	for k := range headers {
 		if find(redactList, k) {
@@ -141,6 +274,19 @@ func RedactHeaders(headers map[string][]string, redactList []string) map[string]
 	return headers
 }
 
+// ParentIDFromHeader parses the parent message ID sent in the TraceParentIDHeader.
+// It returns nil when the header is missing or invalid.
+func ParentIDFromHeader(value string) *uuid.UUID {
+	if value == "" {
+		return nil
+	}
+	parentID, err := uuid.Parse(value)
+	if err != nil {
+		return nil
+	}
+	return &parentID
+}
+
 func find(haystack []string, needle string) bool {
 	for _, hay := range haystack {
 		if hay == needle {
@@ -155,7 +301,7 @@ func BuildPayload(SDKType string, req *http.Request,
 	pathParams map[string]string, urlPath string,
 	redactHeadersList,
 	redactRequestBodyList, redactResponseBodyList []string,
-	errorList []ATError,
+	errorCollector *ErrorCollector,
 	msgID uuid.UUID,
 	parentID *uuid.UUID,
 	config Config,
@@ -163,7 +309,7 @@ func BuildPayload(SDKType string, req *http.Request,
 	if req == nil || req.URL == nil {
 		// Early return with empty payload to prevent any nil pointer panics
 		if config.Debug {
-			log.Println("APIToolkit: nil request or url while building payload.")
+			config.logger().Debug("APIToolkit: nil request or url while building payload.")
 		}
 		return Payload{}
 	}
@@ -187,27 +333,52 @@ func BuildPayload(SDKType string, req *http.Request,
 	if msgID != uuid.Nil {
 		msgIDStr = msgID.String()
 	}
+
+	// Errors reported after this point are recorded on the span instead
+	errorList := errorCollector.Close()
+	breadcrumbs := errorCollector.Breadcrumbs()
+	reqBodySize, respBodySize := len(reqBody), len(respBody)
+
+	// Release the bodies untouched when the capture policy rejects the request
+	if !config.CapturePolicy.Matches(statusCode, errorList, req.Header) {
+		reqBody, respBody = nil, nil
+	}
+	// Outgoing requests usually only carry the host in the URL
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	clientAddress := req.RemoteAddr
+	if h, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientAddress = h
+	}
+
 	return Payload{
-		Host:            req.Host,
-		Method:          req.Method,
-		PathParams:      pathParams,
-		ProtoMajor:      req.ProtoMajor,
-		ProtoMinor:      req.ProtoMinor,
-		QueryParams:     req.URL.Query(),
-		RawURL:          req.URL.RequestURI(),
-		Referer:         req.Referer(),
-		RequestBody:     RedactJSON(reqBody, redactRequestBodyList),
-		RequestHeaders:  RedactHeaders(req.Header, redactedHeaders),
-		ResponseBody:    RedactJSON(respBody, redactResponseBodyList),
-		ResponseHeaders: RedactHeaders(respHeader, redactedHeaders),
-		SdkType:         SDKType,
-		StatusCode:      statusCode,
-		URLPath:         urlPath,
-		Errors:          errorList,
-		ServiceVersion:  serviceVersion,
-		Tags:            config.Tags,
-		MsgID:           msgIDStr,
-		ParentID:        parentIDVal,
+		Host:             host,
+		Method:           req.Method,
+		PathParams:       pathParams,
+		ProtoMajor:       req.ProtoMajor,
+		ProtoMinor:       req.ProtoMinor,
+		QueryParams:      req.URL.Query(),
+		RawURL:           req.URL.RequestURI(),
+		Referer:          req.Referer(),
+		RequestBody:      redactBody(reqBody, redactRequestBodyList),
+		RequestHeaders:   RedactHeaders(req.Header, redactedHeaders),
+		ResponseBody:     redactBody(respBody, redactResponseBodyList),
+		ResponseHeaders:  RedactHeaders(respHeader, redactedHeaders),
+		SdkType:          SDKType,
+		StatusCode:       statusCode,
+		URLPath:          urlPath,
+		Errors:           errorList,
+		Breadcrumbs:      breadcrumbs,
+		ServiceVersion:   serviceVersion,
+		Tags:             config.Tags,
+		MsgID:            msgIDStr,
+		ParentID:         parentIDVal,
+		ClientAddress:    clientAddress,
+		UserAgent:        req.UserAgent(),
+		RequestBodySize:  reqBodySize,
+		ResponseBodySize: respBodySize,
 	}
 }
 
@@ -216,7 +387,7 @@ func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
 	pathParams map[string]string, urlPath string,
 	redactHeadersList,
 	redactRequestBodyList, redactResponseBodyList []string,
-	errorList []ATError,
+	errorCollector *ErrorCollector,
 	msgID uuid.UUID,
 	parentID *uuid.UUID,
 	referer string,
@@ -225,7 +396,7 @@ func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
 	if req == nil || req.URI() == nil {
 		// Early return with empty payload to prevent any nil pointer panics
 		if config.Debug {
-			log.Println("APIToolkit: nil request or client or url while building payload.")
+			config.logger().Debug("APIToolkit: nil request or client or url while building payload.")
 		}
 		return Payload{}
 	}
@@ -256,26 +427,41 @@ func BuildFastHTTPPayload(SDKType string, req *fasthttp.RequestCtx,
 		serviceVersion = &config.ServiceVersion
 	}
 
+	// Errors reported after this point are recorded on the span instead
+	errorList := errorCollector.Close()
+	breadcrumbs := errorCollector.Breadcrumbs()
+	reqBodySize, respBodySize := len(reqBody), len(respBody)
+
+	// Release the bodies untouched when the capture policy rejects the request
+	if !config.CapturePolicy.Matches(statusCode, errorList, reqHeaders) {
+		reqBody, respBody = nil, nil
+	}
+
 	return Payload{
-		Host:            string(req.Host()),
-		Method:          string(req.Method()),
-		PathParams:      pathParams,
-		ProtoMajor:      1, // req.ProtoMajor,
-		ProtoMinor:      1, // req.ProtoMinor,
-		QueryParams:     queryParams,
-		RawURL:          string(req.RequestURI()),
-		Referer:         referer,
-		RequestBody:     RedactJSON(reqBody, redactRequestBodyList),
-		RequestHeaders:  RedactHeaders(reqHeaders, redactedHeaders),
-		ResponseBody:    RedactJSON(respBody, redactResponseBodyList),
-		ResponseHeaders: RedactHeaders(respHeader, redactedHeaders),
-		SdkType:         SDKType,
-		StatusCode:      statusCode,
-		URLPath:         urlPath,
-		Errors:          errorList,
-		ServiceVersion:  serviceVersion,
-		Tags:            config.Tags,
-		MsgID:           msgID.String(),
-		ParentID:        parentIDVal,
+		Host:             string(req.Host()),
+		Method:           string(req.Method()),
+		PathParams:       pathParams,
+		ProtoMajor:       1, // req.ProtoMajor,
+		ProtoMinor:       1, // req.ProtoMinor,
+		QueryParams:      queryParams,
+		RawURL:           string(req.RequestURI()),
+		Referer:          referer,
+		RequestBody:      redactBody(reqBody, redactRequestBodyList),
+		RequestHeaders:   RedactHeaders(reqHeaders, redactedHeaders),
+		ResponseBody:     redactBody(respBody, redactResponseBodyList),
+		ResponseHeaders:  RedactHeaders(respHeader, redactedHeaders),
+		SdkType:          SDKType,
+		StatusCode:       statusCode,
+		URLPath:          urlPath,
+		Errors:           errorList,
+		Breadcrumbs:      breadcrumbs,
+		ServiceVersion:   serviceVersion,
+		Tags:             config.Tags,
+		MsgID:            msgID.String(),
+		ParentID:         parentIDVal,
+		ClientAddress:    req.RemoteIP().String(),
+		UserAgent:        string(req.UserAgent()),
+		RequestBodySize:  reqBodySize,
+		ResponseBodySize: respBodySize,
 	}
 }
@@ -0,0 +1,160 @@
+package apitoolkit
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SlogHandlerOptions configures the handler returned by NewSlogHandler.
+type SlogHandlerOptions struct {
+	// DisableSpanEvents stops log records from being added as events on the current span.
+	DisableSpanEvents bool
+	// ExportLogs also emits log records through the OpenTelemetry logs exporter
+	// set up by ConfigureOpenTelemetryLogs.
+	ExportLogs bool
+}
+
+// SlogHandler wraps a slog.Handler and ties each log record to the APItoolkit request
+// it was logged in, using the span and message ID found in the record's context.
+type SlogHandler struct {
+	inner  slog.Handler
+	opts   SlogHandlerOptions
+	attrs  []slog.Attr // attributes added via WithAttrs, with group prefixes applied
+	prefix string      // group prefix for record attributes
+	// root is the wrapped handler before any group was opened, and derive replays the
+	// WithAttrs and WithGroup calls made since, so request attributes stay at the top level.
+	root   slog.Handler
+	derive []func(slog.Handler) slog.Handler
+}
+
+var _ slog.Handler = (*SlogHandler)(nil)
+
+// NewSlogHandler returns a slog.Handler which adds trace_id, span_id and apitoolkit.msg_id
+// to every record logged with a request context, before passing it on to inner.
+//
+//	logger := slog.New(apitoolkit.NewSlogHandler(slog.NewJSONHandler(os.Stdout, nil), nil))
+//	logger.InfoContext(req.Context(), "creating order")
+func NewSlogHandler(inner slog.Handler, opts *SlogHandlerOptions) *SlogHandler {
+	h := &SlogHandler{inner: inner, root: inner}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	span := trace.SpanFromContext(ctx)
+	spanCtx := span.SpanContext()
+	msgID, hasMsgID := ctx.Value(CurrentRequestMessageID).(uuid.UUID)
+
+	if !h.opts.DisableSpanEvents && span.IsRecording() {
+		span.AddEvent("log", trace.WithTimestamp(record.Time), trace.WithAttributes(h.spanEventAttributes(record)...))
+	}
+	if h.opts.ExportLogs {
+		h.export(ctx, record, msgID, hasMsgID)
+	}
+
+	requestAttrs := []slog.Attr{}
+	if spanCtx.IsValid() {
+		requestAttrs = append(requestAttrs,
+			slog.String("trace_id", spanCtx.TraceID().String()),
+			slog.String("span_id", spanCtx.SpanID().String()),
+		)
+	}
+	if hasMsgID {
+		requestAttrs = append(requestAttrs, slog.String("apitoolkit.msg_id", msgID.String()))
+	}
+	if len(requestAttrs) == 0 {
+		return h.inner.Handle(ctx, record)
+	}
+	if h.prefix == "" {
+		record = record.Clone()
+		record.AddAttrs(requestAttrs...)
+		return h.inner.Handle(ctx, record)
+	}
+	// Add the request attributes before the open groups, instead of nesting them
+	inner := h.root.WithAttrs(requestAttrs)
+	for _, derive := range h.derive {
+		inner = derive(inner)
+	}
+	return inner.Handle(ctx, record)
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.inner = h.inner.WithAttrs(attrs)
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	if h.prefix == "" {
+		h2.root = h2.inner
+	} else {
+		h2.derive = append(h.derive[:len(h.derive):len(h.derive)], func(inner slog.Handler) slog.Handler {
+			return inner.WithAttrs(attrs)
+		})
+	}
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &h2
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.inner = h.inner.WithGroup(name)
+	h2.prefix = h.prefix + name + "."
+	h2.derive = append(h.derive[:len(h.derive):len(h.derive)], func(inner slog.Handler) slog.Handler {
+		return inner.WithGroup(name)
+	})
+	return &h2
+}
+
+// recordAttrs returns the handler's and record's attributes with group prefixes applied.
+func (h *SlogHandler) recordAttrs(record slog.Record) []slog.Attr {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+		return true
+	})
+	return attrs
+}
+
+func (h *SlogHandler) spanEventAttributes(record slog.Record) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("log.severity", record.Level.String()),
+		attribute.String("log.message", record.Message),
+	}
+	for _, a := range h.recordAttrs(record) {
+		attrs = append(attrs, attribute.String(a.Key, a.Value.Resolve().String()))
+	}
+	return attrs
+}
+
+// export emits the record through the global OpenTelemetry LoggerProvider.
+// The span in ctx is used by the logs SDK to correlate the record with its trace.
+func (h *SlogHandler) export(ctx context.Context, record slog.Record, msgID uuid.UUID, hasMsgID bool) {
+	var r otellog.Record
+	r.SetTimestamp(record.Time)
+	r.SetBody(otellog.StringValue(record.Message))
+	// slog levels are spaced 4 apart like OTel severities, with Info at 0 and SeverityInfo at 9
+	r.SetSeverity(otellog.Severity(record.Level + 9))
+	r.SetSeverityText(record.Level.String())
+	for _, a := range h.recordAttrs(record) {
+		r.AddAttributes(otellog.String(a.Key, a.Value.Resolve().String()))
+	}
+	if hasMsgID {
+		r.AddAttributes(otellog.String("apitoolkit.msg_id", msgID.String()))
+	}
+	global.GetLoggerProvider().Logger(instrumentationName).Emit(ctx, r)
+}
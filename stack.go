@@ -0,0 +1,159 @@
+package apitoolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// maxStackDepth bounds the number of frames captured for an error
+const maxStackDepth = 64
+
+// fingerprintFrames is the number of top in-app frames hashed into an error's fingerprint
+const fingerprintFrames = 5
+
+// StackFrame is a single frame of an error's stack trace, innermost first.
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	// InApp is false for frames in the standard library, in dependencies and in this SDK
+	InApp bool `json:"in_app"`
+}
+
+// errorCallers returns the stack carried by err or by one of the errors it wraps.
+// The innermost stack is used, since it is the closest to where the error started.
+// go-errors exposes it with Callers() and pkg/errors with StackTrace(), whose
+// Frame type is a uintptr, so it is read with reflection to avoid the dependency.
+func errorCallers(err error) []uintptr {
+	var callers []uintptr
+	for ; err != nil; err = errors.Unwrap(err) {
+		if pcs := stackOf(err); len(pcs) > 0 {
+			callers = pcs
+		}
+	}
+	return callers
+}
+
+func stackOf(err error) []uintptr {
+	if e, ok := err.(interface{ Callers() []uintptr }); ok {
+		return e.Callers()
+	}
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	trace := method.Call(nil)[0]
+	if trace.Kind() != reflect.Slice || trace.Type().Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+	pcs := make([]uintptr, trace.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(trace.Index(i).Uint())
+	}
+	return pcs
+}
+
+// callers returns the program counters of the calling goroutine, skipping skip frames above the caller.
+func callers(skip int) []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+func stackFrames(pcs []uintptr) []StackFrame {
+	if len(pcs) == 0 {
+		return nil
+	}
+	frames := make([]StackFrame, 0, len(pcs))
+	iter := runtime.CallersFrames(pcs)
+	for {
+		frame, more := iter.Next()
+		if frame.Function != "" {
+			frames = append(frames, StackFrame{
+				Function: frame.Function,
+				File:     frame.File,
+				Line:     frame.Line,
+				InApp:    isInApp(frame.Function, frame.File),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	return frames
+}
+
+var goroot = strings.TrimSuffix(runtime.GOROOT(), "/") + "/"
+
+func isSDKFunction(function string) bool {
+	return strings.HasPrefix(function, instrumentationName+".") || strings.HasPrefix(function, instrumentationName+"/")
+}
+
+func isInApp(function, file string) bool {
+	switch {
+	case isSDKFunction(function):
+		return false
+	case strings.Contains(file, "/pkg/mod/") || strings.Contains(file, "/vendor/") || strings.Contains(file, "@v"):
+		return false
+	case goroot != "/" && strings.HasPrefix(file, goroot):
+		return false
+	case !path.IsAbs(file) && !strings.Contains(strings.SplitN(function, "/", 2)[0], "."):
+		// Binaries built with -trimpath report standard library files relative to GOROOT
+		return strings.HasPrefix(function, "main.")
+	}
+	return true
+}
+
+func formatFrames(frames []StackFrame) string {
+	var sb strings.Builder
+	for _, frame := range frames {
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return sb.String()
+}
+
+// Closure and generic instantiation suffixes change when unrelated code is edited
+var unstableFunctionParts = regexp.MustCompile(`\.func\d+(\.\d+)*|\.gowrap\d+|\[[^\]]*\]`)
+
+// fingerprint hashes the error types and the top in-app functions, so the same error
+// raised from the same place groups together across deploys, line changes and messages.
+// Without in-app frames, e.g. for an error returned to a framework and captured by a middleware,
+// the message is hashed with the top frames outside of this SDK, which are the same for every error.
+func fingerprint(errorType, rootErrorType, message string, frames []StackFrame) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", errorType, rootErrorType)
+	inApp := 0
+	for _, frame := range frames {
+		if frame.InApp {
+			fmt.Fprintln(hash, unstableFunctionParts.ReplaceAllString(frame.Function, ""))
+			inApp++
+			if inApp == fingerprintFrames {
+				break
+			}
+		}
+	}
+	if inApp == 0 {
+		if message != "" {
+			fmt.Fprintln(hash, message)
+		}
+		hashed := 0
+		for _, frame := range frames {
+			if isSDKFunction(frame.Function) {
+				continue
+			}
+			fmt.Fprintln(hash, unstableFunctionParts.ReplaceAllString(frame.Function, ""))
+			hashed++
+			if hashed == fingerprintFrames {
+				break
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
@@ -0,0 +1,138 @@
+package apitoolkit
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ConnectionTiming breaks down the latency of an outgoing request. The DNS, Connect
+// and TLSHandshake phases are zero when an existing connection was reused.
+type ConnectionTiming struct {
+	DNS          time.Duration `json:"dns_ns,omitempty"`
+	Connect      time.Duration `json:"connect_ns,omitempty"`
+	TLSHandshake time.Duration `json:"tls_handshake_ns,omitempty"`
+	// TimeToFirstByte is measured from the start of the request
+	TimeToFirstByte time.Duration `json:"time_to_first_byte_ns,omitempty"`
+	ConnReused      bool          `json:"conn_reused"`
+	RemoteAddr      string        `json:"remote_addr,omitempty"`
+}
+
+// timingPhase is a named phase of ConnectionTiming, as recorded in the metrics.
+type timingPhase struct {
+	name     string
+	duration time.Duration
+}
+
+// phases returns the phases which happened, in order.
+func (t *ConnectionTiming) phases() []timingPhase {
+	var phases []timingPhase
+	for _, p := range []timingPhase{
+		{"dns", t.DNS},
+		{"connect", t.Connect},
+		{"tls", t.TLSHandshake},
+		{"time_to_first_byte", t.TimeToFirstByte},
+	} {
+		if p.duration > 0 {
+			phases = append(phases, p)
+		}
+	}
+	return phases
+}
+
+// attributes returns the span attributes of the timing. The peer address follows the
+// semantic conventions, the phases are in nanoseconds like apitoolkit.duration_ns.
+func (t *ConnectionTiming) attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.Bool("apitoolkit.http.conn_reused", t.ConnReused)}
+	for _, p := range t.phases() {
+		attrs = append(attrs, attribute.Int64("apitoolkit.http."+p.name+"_ns", p.duration.Nanoseconds()))
+	}
+	if host, port, err := net.SplitHostPort(t.RemoteAddr); err == nil {
+		attrs = append(attrs, attribute.String("network.peer.address", host))
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, attribute.Int("network.peer.port", p))
+		}
+	}
+	return attrs
+}
+
+// timingRecorder fills a ConnectionTiming from httptrace hooks,
+// which the transport may call from several goroutines.
+type timingRecorder struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timing       ConnectionTiming
+}
+
+func newTimingRecorder(start time.Time) *timingRecorder {
+	return &timingRecorder{start: start}
+}
+
+func (r *timingRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timing.DNS = time.Since(r.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			// Several addresses may be dialed in parallel, the first dial starts the phase
+			if r.connectStart.IsZero() {
+				r.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err == nil && r.timing.Connect == 0 {
+				r.timing.Connect = time.Since(r.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timing.TLSHandshake = time.Since(r.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timing.ConnReused = info.Reused
+			if info.Conn != nil {
+				r.timing.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		GotFirstResponseByte: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.timing.TimeToFirstByte = time.Since(r.start)
+		},
+	}
+}
+
+// result returns a copy of the timing recorded so far.
+func (r *timingRecorder) result() *ConnectionTiming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	timing := r.timing
+	return &timing
+}
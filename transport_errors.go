@@ -0,0 +1,66 @@
+package apitoolkit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Categories of the failures of outgoing requests which got no response, recorded as
+// the error.type attribute of their span and as the ErrorType of their ATError.
+const (
+	TransportErrorDNS               = "dns_error"
+	TransportErrorConnectionRefused = "connection_refused"
+	TransportErrorTLS               = "tls_error"
+	TransportErrorTimeout           = "timeout"
+	TransportErrorCanceled          = "canceled"
+	TransportErrorConnectionReset   = "connection_reset"
+	TransportErrorOther             = "transport_error"
+)
+
+// ClassifyTransportError returns the category of an error returned by a RoundTripper,
+// so upstream network problems can be told apart from upstream 5xx responses.
+func ClassifyTransportError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return TransportErrorCanceled
+	case errors.As(err, &dnsErr):
+		return TransportErrorDNS
+	case isTLSError(err):
+		return TransportErrorTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return TransportErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return TransportErrorConnectionReset
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return TransportErrorTimeout
+	}
+	return TransportErrorOther
+}
+
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	// Alerts sent by the server have an unexported type
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// withErrorType replaces the Go type of an error with a category.
+func withErrorType(errorType string) ReportOption {
+	return func(e *ATError) {
+		e.ErrorType = errorType
+	}
+}